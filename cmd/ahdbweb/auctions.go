@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAuctionsPage caps the limit param of /api/auctions.
+const maxAuctionsPage = 200
+
+type auctionRow struct {
+	Seller    string  `json:"seller"`
+	TimeLeft  int     `json:"timeLeft"`
+	ItemCount int64   `json:"itemCount"`
+	MinBid    int64   `json:"minBid"`
+	CurBid    int64   `json:"curBid"`
+	Buyout    int64   `json:"buyout"`
+	PerItem   float64 `json:"perItem"` // buyout / itemCount, 0 without a buyout
+}
+
+type auctionsPage struct {
+	ScanID   int64        `json:"scanId"`
+	ItemID   string       `json:"itemId"`
+	Total    int64        `json:"total"`
+	Offset   int64        `json:"offset"`
+	Limit    int64        `json:"limit"`
+	Auctions []auctionRow `json:"auctions"`
+}
+
+// handleAuctions lists the raw auctions of an item in a scan, cheapest per
+// item buyout first, to see which listings are behind a statistic.
+func (s *server) handleAuctions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scanID, err := parseScanIDParam(r, "scanId")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := parseIntParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := parseIntParam(r, "limit", 50)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	limit = min(limit, maxAuctionsPage)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	res := auctionsPage{ScanID: scanID, ItemID: itemID, Offset: offset, Limit: limit, Auctions: []auctionRow{}}
+	done := s.metrics.timeQuery("auctions")
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM auctions WHERE scanId = ? AND itemId = ?`, scanID, itemID,
+	).Scan(&res.Total)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT seller, timeLeft, itemCount, minBid, curBid, buyout
+FROM auctions
+WHERE scanId = ? AND itemId = ?
+ORDER BY buyout = 0, buyout / GREATEST(itemCount, 1), buyout
+LIMIT ? OFFSET ?`, scanID, itemID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a auctionRow
+		var seller sql.NullString
+		if err := rows.Scan(&seller, &a.TimeLeft, &a.ItemCount, &a.MinBid, &a.CurBid, &a.Buyout); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		a.Seller = seller.String
+		if a.ItemCount > 0 {
+			a.PerItem = float64(a.Buyout) / float64(a.ItemCount)
+		}
+		res.Auctions = append(res.Auctions, a)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breaker is a circuit breaker protecting an overloaded DB: after threshold
+// consecutive failed requests, each within window of the previous one, it
+// opens and fails requests fast for cooldown, then lets a single probe
+// request through to decide whether to close again. A nil *breaker is valid
+// and never opens.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+}
+
+func newBreaker(threshold int, window, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, window: window, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow reports whether a request may go through, and if not, how long
+// until the breaker probes again.
+func (b *breaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(now); wait > 0 {
+			return false, wait
+		}
+		b.state = breakerHalfOpen
+		b.openedAt = now
+		return true, 0
+	case breakerHalfOpen:
+		// The probe is still in flight. One that never reports back (its
+		// client went away) is replaced after another cooldown.
+		if wait := b.openedAt.Add(b.cooldown).Sub(now); wait > 0 {
+			return false, wait
+		}
+		b.openedAt = now
+		return true, 0
+	}
+	return true, 0
+}
+
+// record updates the breaker with the outcome of an allowed request.
+func (b *breaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	if now.Sub(b.lastFailure) > b.window {
+		b.failures = 0
+	}
+	b.failures++
+	b.lastFailure = now
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// currentState returns the breaker state, "" when disabled.
+func (b *breaker) currentState() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// release gives up the half-open probe slot of a request whose outcome says
+// nothing about the DB, so the next request probes right away.
+func (b *breaker) release(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.openedAt = now.Add(-b.cooldown)
+	}
+}
+
+// outcome classifies a DB-backed response: 500s (DB errors), 504s and
+// truncated 206s (timeouts) are failures, other 2xx, 304 and 404 mean the
+// DB answered. Other statuses, such as validation errors, say nothing either
+// way.
+func outcome(status int) (failed, known bool) {
+	switch {
+	case status == http.StatusInternalServerError, status == http.StatusGatewayTimeout,
+		status == http.StatusPartialContent:
+		return true, true
+	case status >= 200 && status < 300, status == http.StatusNotModified, status == http.StatusNotFound:
+		return false, true
+	}
+	return false, false
+}
+
+// handler fails the DB-backed handler h with 503 while the breaker is open,
+// and records its outcomes. It goes inside the response cache, so cache hits
+// neither count nor get failed.
+func (b *breaker) handler(h http.HandlerFunc) http.HandlerFunc {
+	if b == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := b.allow(time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(wait.Round(time.Second)/time.Second))))
+			writeErrorCode(w, http.StatusServiceUnavailable, codeDBUnavailable, "database unavailable, try again later")
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			now := time.Now()
+			// A client going away isn't a DB failure, and neither is a
+			// request that didn't complete at all.
+			failed, known := outcome(rec.status)
+			if r.Context().Err() != nil || !known {
+				b.release(now)
+				return
+			}
+			b.record(failed, now)
+		}()
+		h(rec, r)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerOutcomes(t *testing.T) {
+	b := newBreaker(2, time.Minute, time.Hour)
+	status := http.StatusInternalServerError
+	h := b.handler(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+	do := func() int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/api/series", nil))
+		return w.Code
+	}
+
+	do()
+	status = http.StatusBadRequest
+	// Validation errors say nothing about the DB: the failure count stays.
+	do()
+	status = http.StatusInternalServerError
+	do()
+	if got := b.currentState(); got != breakerOpen {
+		t.Fatalf("state = %q after 2 failures around a 400, want open", got)
+	}
+	if code := do(); code != http.StatusServiceUnavailable {
+		t.Fatalf("open breaker answered %d, want 503", code)
+	}
+
+	// Half-open: a 400 probe neither closes the breaker nor holds the probe.
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	b.mu.Unlock()
+	status = http.StatusBadRequest
+	if code := do(); code != http.StatusBadRequest {
+		t.Fatalf("probe answered %d, want the handler's 400", code)
+	}
+	if got := b.currentState(); got != breakerHalfOpen {
+		t.Fatalf("state = %q after a 400 probe, want half-open", got)
+	}
+	status = http.StatusOK
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("second probe answered %d, want 200", code)
+	}
+	if got := b.currentState(); got != breakerClosed {
+		t.Errorf("state = %q after a successful probe, want closed", got)
+	}
+}
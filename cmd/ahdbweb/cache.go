@@ -0,0 +1,161 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedHeaders are the response headers stored along with cached bodies.
+var cachedHeaders = []string{"Content-Type", "Content-Disposition", "ETag", "Last-Modified"}
+
+type cacheEntry struct {
+	key     string
+	expires time.Time
+	header  http.Header
+	body    []byte
+}
+
+// responseCache is an in-memory LRU cache of successful GET responses with a
+// TTL. Responses whose range ends over an hour ago only cover historical
+// scans, which don't change, so they are kept for historicalTTL instead.
+type responseCache struct {
+	ttl           time.Duration
+	historicalTTL time.Duration
+	maxEntries    int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+func newResponseCache(ttl, historicalTTL time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:           ttl,
+		historicalTTL: historicalTTL,
+		maxEntries:    maxEntries,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+}
+
+func (c *responseCache) get(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if now.After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *responseCache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cacheKey normalizes the request into a key: path, sorted query params and
+// the Accept header, which can select the CSV format.
+func cacheKey(r *http.Request) string {
+	q := url.Values{}
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			q.Add(k, strings.TrimSpace(v))
+		}
+	}
+	return r.URL.Path + "?" + q.Encode() + "|" + r.Header.Get("Accept")
+}
+
+func (c *responseCache) ttlFor(r *http.Request, now time.Time) time.Duration {
+	to, err := parseTimeParam(r, "to", now.Unix())
+	// lastScans ignores to and always covers the latest scans.
+	if err == nil && to < now.Add(-time.Hour).Unix() && !r.URL.Query().Has("lastScans") {
+		return c.historicalTTL
+	}
+	return c.ttl
+}
+
+// teeWriter passes the response through while keeping a copy of it.
+type teeWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (t *teeWriter) WriteHeader(status int) {
+	if t.status == 0 {
+		t.status = status
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	t.body = append(t.body, p...)
+	return t.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (t *teeWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+// handler serves GET requests from the cache, setting X-Cache to HIT or MISS.
+func (c *responseCache) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+		now := time.Now()
+		key := cacheKey(r)
+		if e, ok := c.get(key, now); ok {
+			for k, vs := range e.header {
+				w.Header()[k] = vs
+			}
+			w.Header().Set("X-Cache", "HIT")
+			if notModified(w, r) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(e.body)
+			return
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		tw := &teeWriter{ResponseWriter: w}
+		next(tw, r)
+		if tw.status != http.StatusOK {
+			return
+		}
+		e := &cacheEntry{key: key, expires: now.Add(c.ttlFor(r, now)), header: http.Header{}, body: tw.body}
+		for _, k := range cachedHeaders {
+			if v := w.Header().Get(k); v != "" {
+				e.header.Set(k, v)
+			}
+		}
+		c.put(e)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheExpiry(t *testing.T) {
+	c := newResponseCache(time.Minute, time.Hour, 10)
+	now := time.Unix(1_700_000_000, 0)
+	c.put(&cacheEntry{key: "k", expires: now.Add(time.Minute)})
+
+	if _, ok := c.get("k", now.Add(59*time.Second)); !ok {
+		t.Error("entry missing before its ttl")
+	}
+	if _, ok := c.get("k", now.Add(time.Minute)); !ok {
+		t.Error("entry missing at exactly its ttl")
+	}
+	if _, ok := c.get("k", now.Add(time.Minute+time.Second)); ok {
+		t.Error("entry still served after its ttl")
+	}
+	if _, ok := c.entries["k"]; ok || c.lru.Len() != 0 {
+		t.Error("expired entry not removed")
+	}
+}
+
+func TestResponseCacheTTLFor(t *testing.T) {
+	c := newResponseCache(time.Minute, time.Hour, 10)
+	now := time.Unix(1_700_000_000, 0)
+	old := now.Add(-2 * time.Hour).Unix()
+	recent := now.Add(-30 * time.Minute).Unix()
+	tests := []struct {
+		name  string
+		query string
+		want  time.Duration
+	}{
+		{"no to", "itemId=i1", time.Minute},
+		{"recent to", fmt.Sprintf("itemId=i1&to=%d", recent), time.Minute},
+		{"historical to", fmt.Sprintf("itemId=i1&to=%d", old), time.Hour},
+		{"historical to with lastScans", fmt.Sprintf("itemId=i1&to=%d&lastScans=5", old), time.Minute},
+		{"lastScans alone", "itemId=i1&lastScans=5", time.Minute},
+		{"invalid to", "itemId=i1&to=soon", time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/series?"+tt.query, nil)
+			if got := c.ttlFor(r, now); got != tt.want {
+				t.Errorf("ttlFor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheLRUEviction(t *testing.T) {
+	c := newResponseCache(time.Minute, time.Hour, 3)
+	now := time.Unix(1_700_000_000, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		c.put(&cacheEntry{key: key, expires: now.Add(time.Minute)})
+	}
+	// Using a makes b the least recently used entry.
+	if _, ok := c.get("a", now); !ok {
+		t.Fatal("a missing")
+	}
+	c.put(&cacheEntry{key: "d", expires: now.Add(time.Minute)})
+
+	if c.lru.Len() != 3 || len(c.entries) != 3 {
+		t.Errorf("%d entries, %d in the LRU list, want 3", len(c.entries), c.lru.Len())
+	}
+	if _, ok := c.get("b", now); ok {
+		t.Error("least recently used entry b not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.get(key, now); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxComparePairs caps the realm:faction pairs of /api/realms/compare, each
+// of which costs a series query.
+const maxComparePairs = 6
+
+// parseRealmPairsParam parses the comma separated realm:faction pairs of the
+// realms param, dropping duplicates. Realm names may contain spaces but not
+// colons.
+func parseRealmPairsParam(r *http.Request) ([]realmFaction, error) {
+	var pairs []realmFaction
+	seen := make(map[realmFaction]bool)
+	for _, raw := range strings.Split(r.URL.Query().Get("realms"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		realm, faction, ok := strings.Cut(raw, ":")
+		rf := realmFaction{strings.TrimSpace(realm), strings.TrimSpace(faction)}
+		if !ok || rf.Realm == "" || rf.Faction == "" {
+			return nil, fmt.Errorf("invalid realms entry %q (expected realm:faction)", raw)
+		}
+		if rf.Realm == wildcard || rf.Faction == wildcard || strings.EqualFold(rf.Faction, factionBoth) {
+			return nil, fmt.Errorf("invalid realms entry %q (wildcards and both are not supported)", raw)
+		}
+		if seen[rf] {
+			continue
+		}
+		seen[rf] = true
+		pairs = append(pairs, rf)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("missing realms")
+	}
+	if len(pairs) > maxComparePairs {
+		return nil, fmt.Errorf("too many realms (max %d)", maxComparePairs)
+	}
+	return pairs, nil
+}
+
+// handleRealmCompare returns the series of one item on each of the realm:
+// faction pairs of the realms param, in the requested order, for cross realm
+// price comparison. The other params are the /api/series ones.
+func (s *server) handleRealmCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pairs, err := parseRealmPairsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var it item
+	err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := make([]seriesResponse, 0, len(pairs))
+	seen := make(map[realmFaction]bool)
+	for _, rf := range pairs {
+		p.Realm, p.Faction, err = s.canonicalRealmFaction(ctx, rf.Realm, rf.Faction)
+		if err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+		// Pairs differing only in casing are the same series.
+		if seen[realmFaction{p.Realm, p.Faction}] {
+			continue
+		}
+		seen[realmFaction{p.Realm, p.Faction}] = true
+		series, err := s.loadSeries(ctx, p, []string{itemID})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+		res = append(res, p.response(it, series))
+	}
+	writeJSONWithETag(w, r, seriesListJSON(res))
+}
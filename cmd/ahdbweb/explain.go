@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// explainIfSlow logs the EXPLAIN plan of query when it took longer than
+// s.explainSlow since start, to spot missing indexes. The plan is fetched in
+// the background so the response isn't delayed. It does nothing when
+// -explain is off.
+func (s *server) explainIfSlow(db *sql.DB, name string, start time.Time, query string, args []any) {
+	took := time.Since(start)
+	if s.explainSlow <= 0 || took < s.explainSlow {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		plan, err := explain(ctx, db, query, args)
+		if err != nil {
+			log.Printf("slow %s query (%v), EXPLAIN failed: %v", name, took.Round(time.Millisecond), err)
+			return
+		}
+		log.Printf("slow %s query (%v), plan:\n%s", name, took.Round(time.Millisecond), plan)
+	}()
+}
+
+// explain runs EXPLAIN on query and formats the plan rows as
+// "column=value" lines. The column set depends on the server version.
+func explain(ctx context.Context, db *sql.DB, query string, args []any) (string, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var b strings.Builder
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		b.WriteString("  ")
+		for i, col := range cols {
+			if values[i] == nil {
+				continue
+			}
+			fmt.Fprintf(&b, " %s=%s", col, values[i])
+		}
+		b.WriteByte('\n')
+	}
+	return strings.TrimSuffix(b.String(), "\n"), rows.Err()
+}
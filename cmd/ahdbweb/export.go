@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushEvery is how many auction rows a scan export writes between
+// flushes.
+const exportFlushEvery = 1000
+
+type exportRow struct {
+	ItemID    string `json:"itemId"`
+	Buyout    int64  `json:"buyout"`
+	Bid       int64  `json:"bid"`
+	ItemCount int64  `json:"itemCount"`
+}
+
+// handleScanExport streams every auction of a scan (optionally of one item)
+// as CSV, or NDJSON with format=ndjson, writing rows as they are read so
+// memory stays flat however big the scan is.
+func (s *server) handleScanExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	scanID, err := parseScanIDParam(r, "scanId")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if itemID != "" {
+		if err := validateItemID(itemID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "ndjson":
+	default:
+		writeError(w, http.StatusBadRequest, "invalid format (expected csv or ndjson)")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	var realm, faction string
+	var ts int64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT realm, faction, UNIX_TIMESTAMP(ts) FROM scanmeta WHERE id = ?`, scanID,
+	).Scan(&realm, &faction, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErrorCode(w, http.StatusNotFound, codeScanNotFound, "scan not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query := `SELECT itemId, buyout, curBid, itemCount FROM auctions WHERE scanId = ?`
+	args := []any{scanID}
+	if itemID != "" {
+		query += ` AND itemId = ?`
+		args = append(args, itemID)
+	}
+	done := s.metrics.timeQuery("scan_export")
+	rows, err := s.readDB.QueryContext(ctx, query+` ORDER BY itemId`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("ahdb-scan-%d-%s-%s-%s.%s", scanID, strings.ReplaceAll(realm, " ", "_"), faction,
+		time.Unix(ts, 0).UTC().Format("20060102T1504"), format)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		_ = cw.Write([]string{"itemId", "buyout", "bid", "itemCount"})
+	}
+	// The status is sent, so errors past this point can only cut the
+	// download short.
+	n := 0
+	for rows.Next() {
+		var row exportRow
+		if err := rows.Scan(&row.ItemID, &row.Buyout, &row.Bid, &row.ItemCount); err != nil {
+			log.Printf("scan %d export: %v", scanID, err)
+			return
+		}
+		if format == "csv" {
+			err = cw.Write([]string{row.ItemID, strconv.FormatInt(row.Buyout, 10),
+				strconv.FormatInt(row.Bid, 10), strconv.FormatInt(row.ItemCount, 10)})
+		} else {
+			err = enc.Encode(row)
+		}
+		if err != nil {
+			return
+		}
+		if n++; n%exportFlushEvery == 0 {
+			cw.Flush()
+			_ = rc.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("scan %d export: %v", scanID, err)
+	}
+	cw.Flush()
+	_ = rc.Flush()
+	done()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeResult is what a fakeDB query returns.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// fakeQuery answers one query of a fakeDB, given its SQL and args.
+type fakeQuery func(query string, args []driver.NamedValue) fakeResult
+
+// newFakeDB returns a *sql.DB whose queries are all answered by fn, for
+// testing handlers without MySQL.
+func newFakeDB(t *testing.T, fn fakeQuery) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(fakeConnector{fn})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// fakeRowsFor answers queries containing match with res, and fails others.
+func fakeRowsFor(match string, res fakeResult) fakeQuery {
+	return func(query string, _ []driver.NamedValue) fakeResult {
+		if !strings.Contains(query, match) {
+			return fakeResult{err: errors.New("unexpected query: " + query)}
+		}
+		return res
+	}
+}
+
+type fakeConnector struct{ fn fakeQuery }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use sql.OpenDB") }
+
+type fakeConn struct{ fn fakeQuery }
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.fn(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// pointField is a JSON field of seriesPoint.
+type pointField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// pointFields lists the seriesPoint JSON fields in struct order.
+var pointFields = func() []pointField {
+	t := reflect.TypeOf(seriesPoint{})
+	var fields []pointField
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("json")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fields = append(fields, pointField{name, i, opts == "omitempty"})
+	}
+	return fields
+}()
+
+// parseFieldsParam parses the comma separated fields param restricting the
+// series points to those JSON keys, e.g. fields=ts,median,n. It returns nil,
+// meaning all fields, when the param is absent.
+func parseFieldsParam(r *http.Request) (map[string]bool, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("fields"))
+	if raw == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(pointFields))
+	for _, f := range pointFields {
+		known[f.name] = true
+	}
+	keep := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			allowed := make([]string, len(pointFields))
+			for i, f := range pointFields {
+				allowed[i] = f.name
+			}
+			return nil, fmt.Errorf("invalid field %q (allowed: %s)", name, strings.Join(allowed, ", "))
+		}
+		keep[name] = true
+	}
+	if len(keep) == 0 {
+		return nil, nil
+	}
+	return keep, nil
+}
+
+// projectedPoint is a seriesPoint written with only the keep fields, as
+// requested with the fields param. Omitempty fields stay omitted when empty.
+type projectedPoint struct {
+	seriesPoint
+	keep map[string]bool
+}
+
+func (pt projectedPoint) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(pt.seriesPoint)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, f := range pointFields {
+		fv := v.Field(f.index)
+		if !pt.keep[f.name] || (f.omitEmpty && fv.IsZero()) {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:", f.name)
+		b, err := json.Marshal(fv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func projectPoints(points []seriesPoint, keep map[string]bool) []projectedPoint {
+	if points == nil {
+		return nil
+	}
+	res := make([]projectedPoint, len(points))
+	for i, pt := range points {
+		res[i] = projectedPoint{pt, keep}
+	}
+	return res
+}
+
+// projectedSeries is a seriesResponse with projected points, which shadow
+// the embedded ones in JSON.
+type projectedSeries struct {
+	seriesResponse
+	Points   []projectedPoint            `json:"points"`
+	Factions map[string][]projectedPoint `json:"factions,omitempty"`
+}
+
+// jsonValue returns what to write as resp's JSON: resp itself, plainly
+// encoded, unless the fields param restricts its points.
+func (resp seriesResponse) jsonValue() any {
+	if resp.fields == nil {
+		return resp
+	}
+	res := projectedSeries{seriesResponse: resp, Points: projectPoints(resp.Points, resp.fields)}
+	if resp.Factions != nil {
+		res.Factions = make(map[string][]projectedPoint, len(resp.Factions))
+		for faction, points := range resp.Factions {
+			res.Factions[faction] = projectPoints(points, resp.fields)
+		}
+	}
+	return res
+}
+
+// seriesListJSON is jsonValue for a list of responses sharing their params.
+func seriesListJSON(res []seriesResponse) any {
+	if len(res) == 0 || res[0].fields == nil {
+		return res
+	}
+	list := make([]any, len(res))
+	for i, resp := range res {
+		list[i] = resp.jsonValue()
+	}
+	return list
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSeriesFieldsProjection(t *testing.T) {
+	resp := seriesResponse{Points: []seriesPoint{{TS: 100, Median: 5, N: 3, IsLowest: true}}}
+	keys := func(v any) []string {
+		t.Helper()
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res struct{ Points []map[string]any }
+		if err := json.Unmarshal(b, &res); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, f := range pointFields {
+			if _, ok := res.Points[0][f.name]; ok {
+				keys = append(keys, f.name)
+			}
+		}
+		return keys
+	}
+
+	if _, ok := resp.jsonValue().(seriesResponse); !ok {
+		t.Errorf("jsonValue without fields = %T, want the plain seriesResponse", resp.jsonValue())
+	}
+	if got := keys(resp.jsonValue()); len(got) != 19 {
+		t.Errorf("default keys = %v, want the 18 non-omitempty ones and isLowest", got)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/?fields=ts,median,isHighest,isLowest", nil)
+	var err error
+	if resp.fields, err = parseFieldsParam(r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys(resp.jsonValue()), []string{"ts", "median", "isLowest"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("projected keys = %v, want %v", got, want)
+	}
+}
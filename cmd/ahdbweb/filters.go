@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// seriesFilter maps an optional integer query param to a condition on a
+// column of the items table (aliased i).
+type seriesFilter struct {
+	param  string
+	column string
+	op     string
+}
+
+// seriesFilters are the supported filters. items has no item level column,
+// only MinLevel, the level required to use the item, so minLevel and maxLevel
+// both bound the required level: maxLevel caps it, it isn't an item level cap.
+var seriesFilters = []seriesFilter{
+	{param: "minLevel", column: "i.MinLevel", op: ">="},
+	{param: "maxLevel", column: "i.MinLevel", op: "<="},
+	{param: "quality", column: "i.Rarity", op: "="},
+}
+
+// filterCond is one parsed filter: an SQL condition and its argument.
+type filterCond struct {
+	cond string
+	arg  int64
+}
+
+// parseSeriesFilters returns the conditions for the filter params present
+// in r, recording invalid ones in fe.
+func parseSeriesFilters(r *http.Request, fe fieldErrors) []filterCond {
+	var res []filterCond
+	for _, f := range seriesFilters {
+		raw := strings.TrimSpace(r.URL.Query().Get(f.param))
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v < 0 {
+			fe.add(f.param, fmt.Errorf("invalid %s", f.param))
+			continue
+		}
+		res = append(res, filterCond{cond: fmt.Sprintf("%s %s ?", f.column, f.op), arg: v})
+	}
+	return res
+}
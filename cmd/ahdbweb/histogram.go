@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type histogramBin struct {
+	Lo    int64 `json:"lo"`
+	Hi    int64 `json:"hi"`
+	Count int   `json:"count"`
+}
+
+// histogramOutliers are the Tukey fences (Q1-1.5*IQR, Q3+1.5*IQR) of the
+// histogram prices and how many prices fall outside of them.
+type histogramOutliers struct {
+	Lo    float64 `json:"lo"`
+	Hi    float64 `json:"hi"`
+	Count int     `json:"count"`
+}
+
+func findOutliers(sortedPrices []float64) *histogramOutliers {
+	q1, _, q3 := quartilesSorted(sortedPrices)
+	iqr := q3 - q1
+	o := &histogramOutliers{Lo: q1 - 1.5*iqr, Hi: q3 + 1.5*iqr}
+	for _, p := range sortedPrices {
+		if p < o.Lo || p > o.Hi {
+			o.Count++
+		}
+	}
+	return o
+}
+
+type histogramResponse struct {
+	ItemID  string         `json:"itemId"`
+	ScanID  int64          `json:"scanId"`
+	TS      int64          `json:"ts"`
+	TSISO   string         `json:"tsIso"`
+	Unit    string         `json:"unit"`
+	TrimPct int            `json:"trimPct"`
+	Scale   string         `json:"scale"`
+	N       int            `json:"n"`
+	Min     float64        `json:"min"`
+	Max     float64        `json:"max"`
+	Bins    []histogramBin `json:"bins"`
+	// BinCount is the number of bins, as chosen with bins=auto.
+	BinCount int `json:"binCount"`
+	// Clamped counts prices outside the loBound/hiBound range that were
+	// put in the first or last bin.
+	Clamped int    `json:"clamped"`
+	MinFmt  string `json:"minFmt,omitempty"`
+	MaxFmt  string `json:"maxFmt,omitempty"`
+	// Outliers is set with outliers=true; outliers are still counted in Bins.
+	Outliers *histogramOutliers `json:"outliers,omitempty"`
+}
+
+// Histogram bin counts are clamped to [minBins, maxBins]. autoBins, from
+// bins=auto or bins=0, picks the count with the Freedman–Diaconis rule.
+const (
+	minBins  = 5
+	maxBins  = 120
+	autoBins = 0
+)
+
+func parseBinsParam(r *http.Request) (int, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("bins"))
+	if raw == "" {
+		return 24, nil
+	}
+	if raw == "auto" {
+		return autoBins, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.New("invalid bins (expected a number or auto)")
+	}
+	if v == autoBins {
+		return autoBins, nil
+	}
+	return min(max(v, minBins), maxBins), nil
+}
+
+// freedmanDiaconisBins returns the bin count for bins of width
+// 2*IQR/cbrt(n) over [lo, hi], measured on the log1p scale for log
+// histograms, clamped to [minBins, maxBins].
+func freedmanDiaconisBins(sortedPrices []float64, lo, hi int64, logScale bool) int {
+	n := len(sortedPrices)
+	if n == 0 {
+		return minBins
+	}
+	f := func(v float64) float64 { return v }
+	if logScale {
+		f = math.Log1p
+	}
+	q1, _, q3 := quartilesSorted(sortedPrices)
+	width := 2 * (f(q3) - f(q1)) / math.Cbrt(float64(n))
+	span := f(float64(hi)) - f(float64(lo))
+	if width <= 0 || span <= 0 {
+		return minBins
+	}
+	return int(min(max(math.Ceil(span/width), minBins), maxBins))
+}
+
+// parseBoundsParams parses the optional loBound/hiBound histogram range,
+// which must be given together.
+func parseBoundsParams(r *http.Request) (lo, hi int64, ok bool, _ error) {
+	q := r.URL.Query()
+	if !q.Has("loBound") && !q.Has("hiBound") {
+		return 0, 0, false, nil
+	}
+	lo, err := parseIntParam(r, "loBound", -1)
+	if err != nil || lo < 0 {
+		return 0, 0, false, errors.New("invalid loBound")
+	}
+	hi, err = parseIntParam(r, "hiBound", -1)
+	if err != nil || hi < 0 {
+		return 0, 0, false, errors.New("invalid hiBound")
+	}
+	if lo >= hi {
+		return 0, 0, false, errors.New("loBound must be < hiBound")
+	}
+	return lo, hi, true, nil
+}
+
+func parseScanIDParam(r *http.Request, key string) (int64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(key))
+	if raw == "" {
+		return 0, fmt.Errorf("missing %s", key)
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid %s", key)
+	}
+	return v, nil
+}
+
+// makeHistogram buckets the sorted prices into bins of equal width spanning
+// [lo, hi]. Prices outside that range are clamped into the edge bins and
+// counted in clamped.
+func makeHistogram(sortedPrices []float64, bins int, lo, hi int64) (res []histogramBin, clamped int) {
+	n := len(sortedPrices)
+	if n == 0 {
+		return nil, 0
+	}
+	clamped = countOutside(sortedPrices, lo, hi)
+	if lo == hi {
+		return []histogramBin{{Lo: lo, Hi: hi, Count: n}}, clamped
+	}
+	if bins <= 0 {
+		bins = 24
+	}
+
+	rng := hi - lo
+	width := rng / int64(bins)
+	if rng%int64(bins) != 0 {
+		width++
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	res = make([]histogramBin, bins)
+	for i := 0; i < bins; i++ {
+		binLo := lo + int64(i)*width
+		res[i] = histogramBin{Lo: binLo, Hi: binLo + width}
+	}
+	for _, p := range sortedPrices {
+		idx := int((p - float64(lo)) / float64(width))
+		if p < float64(lo) {
+			idx = 0
+		}
+		if idx >= bins {
+			idx = bins - 1
+		}
+		res[idx].Count++
+	}
+	return res, clamped
+}
+
+// countOutside returns how many of the sorted prices are outside [lo, hi].
+func countOutside(sortedPrices []float64, lo, hi int64) int {
+	below := sort.Search(len(sortedPrices), func(i int) bool { return sortedPrices[i] >= float64(lo) })
+	above := len(sortedPrices) - sort.Search(len(sortedPrices), func(i int) bool { return sortedPrices[i] > float64(hi) })
+	return below + above
+}
+
+func parseScaleParam(r *http.Request) (string, error) {
+	scale := strings.TrimSpace(r.URL.Query().Get("scale"))
+	switch scale {
+	case "":
+		return "linear", nil
+	case "linear", "log":
+		return scale, nil
+	default:
+		return "", errors.New("invalid scale (expected linear or log)")
+	}
+}
+
+// makeLogHistogram is makeHistogram with bins evenly spaced on a log(1+price)
+// scale, so 0 prices are fine. Bin edges are rounded to the nearest copper.
+func makeLogHistogram(sortedPrices []float64, bins int, lo, hi int64) (res []histogramBin, clamped int) {
+	n := len(sortedPrices)
+	if n == 0 {
+		return nil, 0
+	}
+	clamped = countOutside(sortedPrices, lo, hi)
+	if lo == hi {
+		return []histogramBin{{Lo: lo, Hi: hi, Count: n}}, clamped
+	}
+	if bins <= 0 {
+		bins = 24
+	}
+
+	logLo := math.Log1p(float64(lo))
+	step := (math.Log1p(float64(hi)) - logLo) / float64(bins)
+	edge := func(i int) int64 {
+		switch i {
+		case 0:
+			return lo
+		case bins:
+			return hi
+		}
+		return int64(math.Round(math.Expm1(logLo + float64(i)*step)))
+	}
+
+	res = make([]histogramBin, bins)
+	for i := 0; i < bins; i++ {
+		res[i] = histogramBin{Lo: edge(i), Hi: edge(i + 1)}
+	}
+	for _, p := range sortedPrices {
+		idx := int((math.Log1p(p) - logLo) / step)
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= bins {
+			idx = bins - 1
+		}
+		res[idx].Count++
+	}
+	return res, clamped
+}
+
+// histogramParams are the validated query parameters shared by the histogram
+// endpoints.
+type histogramParams struct {
+	Unit        string
+	RoundMode   string
+	PriceExpr   string
+	PriceFilter string
+	TrimPct     int
+	Bins        int
+	Scale       string
+	Humanize    bool
+	Outliers    bool
+	// LoBound and HiBound fix the bins range when HasBounds is set.
+	LoBound   int64
+	HiBound   int64
+	HasBounds bool
+	// PriceLimits drop out of range prices, before TrimPct applies.
+	PriceLimits priceLimits
+}
+
+func parseHistogramParams(r *http.Request) (histogramParams, error) {
+	var p histogramParams
+	var err error
+	fe := fieldErrors{}
+	p.RoundMode, err = parseRoundModeParam(r)
+	fe.add("roundMode", err)
+	p.Unit, p.PriceExpr, err = parseUnitParam(r, p.RoundMode)
+	fe.add("unit", err)
+	excludeZero, err := parseBoolParam(r, "excludeZeroUnitPrice")
+	fe.add("excludeZeroUnitPrice", err)
+	p.PriceFilter = priceFilter(p.Unit, p.PriceExpr, excludeZero)
+	p.TrimPct, err = parseTrimPctParam(r)
+	fe.add("trimPct", err)
+	p.Bins, err = parseBinsParam(r)
+	fe.add("bins", err)
+	p.Humanize, err = parseBoolParam(r, "humanize")
+	fe.add("humanize", err)
+	p.Scale, err = parseScaleParam(r)
+	fe.add("scale", err)
+	p.Outliers, err = parseBoolParam(r, "outliers")
+	fe.add("outliers", err)
+	p.LoBound, p.HiBound, p.HasBounds, err = parseBoundsParams(r)
+	fe.add("loBound", err)
+	p.PriceLimits = parsePriceLimitsParams(r, fe)
+	return p, fe.err()
+}
+
+// priceRange returns the whole copper range covering the sorted prices.
+func priceRange(sortedPrices []float64) (lo, hi int64) {
+	if len(sortedPrices) == 0 {
+		return 0, 0
+	}
+	return int64(math.Floor(sortedPrices[0])), int64(math.Ceil(sortedPrices[len(sortedPrices)-1]))
+}
+
+// histogram builds the response for the already trimmed prices of a scan,
+// binned over [lo, hi] unless p has explicit bounds.
+func (p histogramParams) histogram(itemID string, scanID, ts int64, prices []float64, lo, hi int64) histogramResponse {
+	makeBins := makeHistogram
+	if p.Scale == "log" {
+		makeBins = makeLogHistogram
+	}
+	if p.HasBounds {
+		lo, hi = p.LoBound, p.HiBound
+	}
+	bins := p.Bins
+	if bins == autoBins {
+		bins = freedmanDiaconisBins(prices, lo, hi, p.Scale == "log")
+	}
+	var minV, maxV float64
+	if len(prices) > 0 {
+		minV, maxV = prices[0], prices[len(prices)-1]
+	}
+	hbins, clamped := makeBins(prices, bins, lo, hi)
+	resp := histogramResponse{
+		ItemID:  itemID,
+		ScanID:  scanID,
+		TS:      ts,
+		TSISO:   formatTS(ts),
+		Unit:    p.Unit,
+		TrimPct: p.TrimPct,
+		Scale:   p.Scale,
+		N:       len(prices),
+		Min:     minV,
+		Max:     maxV,
+		Bins:    hbins,
+		Clamped: clamped,
+
+		BinCount: bins,
+	}
+	if p.Outliers && len(prices) > 0 {
+		resp.Outliers = findOutliers(prices)
+	}
+	if p.Humanize {
+		resp.MinFmt = formatCopperFloat(minV)
+		resp.MaxFmt = formatCopperFloat(maxV)
+	}
+	return resp
+}
+
+func (s *server) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scanID, err := parseScanIDParam(r, "scanId")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parseHistogramParams(r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.histogramTimeout)
+	defer cancel()
+
+	acc, err := s.loadScan(ctx, "histogram", p.PriceExpr, p.PriceFilter, scanID, itemID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	prices := trimSorted(p.PriceLimits.apply(acc.prices), p.TrimPct)
+	lo, hi := priceRange(prices)
+	writeJSONWithETag(w, r, p.histogram(itemID, scanID, acc.ts, prices, lo, hi))
+}
+
+type histogramCompareResponse struct {
+	ItemID string            `json:"itemId"`
+	A      histogramResponse `json:"a"`
+	B      histogramResponse `json:"b"`
+}
+
+// handleHistogramCompare returns the histograms of an item in two scans,
+// binned over the same range so they can be overlaid.
+func (s *server) handleHistogramCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scanIDA, err := parseScanIDParam(r, "scanIdA")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scanIDB, err := parseScanIDParam(r, "scanIdB")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parseHistogramParams(r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.histogramTimeout)
+	defer cancel()
+
+	accA, err := s.loadScan(ctx, "histogram", p.PriceExpr, p.PriceFilter, scanIDA, itemID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	accB, err := s.loadScan(ctx, "histogram", p.PriceExpr, p.PriceFilter, scanIDB, itemID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Checked after the price limits, which may drop every price of a scan
+	// and would otherwise pull the shared range down to 0.
+	pricesA := trimSorted(p.PriceLimits.apply(accA.prices), p.TrimPct)
+	pricesB := trimSorted(p.PriceLimits.apply(accB.prices), p.TrimPct)
+	if len(pricesA) == 0 {
+		writeError(w, http.StatusBadRequest, "no auctions for item in scanIdA")
+		return
+	}
+	if len(pricesB) == 0 {
+		writeError(w, http.StatusBadRequest, "no auctions for item in scanIdB")
+		return
+	}
+	loA, hiA := priceRange(pricesA)
+	loB, hiB := priceRange(pricesB)
+	lo, hi := min(loA, loB), max(hiA, hiB)
+	if p.Bins == autoBins {
+		// Both histograms need the same bins to be overlaid.
+		blo, bhi := lo, hi
+		if p.HasBounds {
+			blo, bhi = p.LoBound, p.HiBound
+		}
+		logScale := p.Scale == "log"
+		p.Bins = max(freedmanDiaconisBins(pricesA, blo, bhi, logScale), freedmanDiaconisBins(pricesB, blo, bhi, logScale))
+	}
+	writeJSONWithETag(w, r, histogramCompareResponse{
+		ItemID: itemID,
+		A:      p.histogram(itemID, scanIDA, accA.ts, pricesA, lo, hi),
+		B:      p.histogram(itemID, scanIDB, accB.ts, pricesB, lo, hi),
+	})
+}
+
+// maxHistogramBatch caps the scanIds of /api/histogram/batch.
+const maxHistogramBatch = 24
+
+// parseScanIDsParam parses a comma separated list of scan ids, keeping their
+// order and dropping duplicates.
+func parseScanIDsParam(r *http.Request, key string) ([]int64, error) {
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, raw := range strings.Split(r.URL.Query().Get(key), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid %s entry %q", key, raw)
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		ids = append(ids, v)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing %s", key)
+	}
+	if len(ids) > maxHistogramBatch {
+		return nil, fmt.Errorf("too many %s (max %d)", key, maxHistogramBatch)
+	}
+	return ids, nil
+}
+
+// parseBinRangeParam parses whether batch histograms are binned over the
+// range of all their scans (shared, the default, for animating a timeline)
+// or each over its own (per_scan).
+func parseBinRangeParam(r *http.Request) (string, error) {
+	rng := strings.TrimSpace(r.URL.Query().Get("range"))
+	switch rng {
+	case "":
+		return "shared", nil
+	case "shared", "per_scan":
+		return rng, nil
+	default:
+		return "", errors.New("invalid range (expected shared or per_scan)")
+	}
+}
+
+// handleHistogramBatch returns the histograms of an item in each of the
+// scanIds, in the requested order, saving a round trip per scan when
+// scrubbing through a timeline.
+func (s *server) handleHistogramBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scanIDs, err := parseScanIDsParam(r, "scanIds")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	binRange, err := parseBinRangeParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parseHistogramParams(r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.histogramTimeout)
+	defer cancel()
+
+	accs := make([]scanAccumulator, len(scanIDs))
+	prices := make([][]float64, len(scanIDs))
+	var lo, hi int64
+	first := true
+	for i, scanID := range scanIDs {
+		accs[i], err = s.loadScan(ctx, "histogram", p.PriceExpr, p.PriceFilter, scanID, itemID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		prices[i] = trimSorted(p.PriceLimits.apply(accs[i].prices), p.TrimPct)
+		if len(prices[i]) == 0 {
+			continue
+		}
+		plo, phi := priceRange(prices[i])
+		if first {
+			lo, hi, first = plo, phi, false
+		}
+		lo, hi = min(lo, plo), max(hi, phi)
+	}
+	if binRange == "shared" && p.Bins == autoBins {
+		// Shared bins need the same count too.
+		blo, bhi := lo, hi
+		if p.HasBounds {
+			blo, bhi = p.LoBound, p.HiBound
+		}
+		bins := minBins
+		for _, sp := range prices {
+			if len(sp) > 0 {
+				bins = max(bins, freedmanDiaconisBins(sp, blo, bhi, p.Scale == "log"))
+			}
+		}
+		p.Bins = bins
+	}
+
+	res := make([]histogramResponse, len(scanIDs))
+	for i, scanID := range scanIDs {
+		if binRange == "per_scan" {
+			lo, hi = priceRange(prices[i])
+		}
+		res[i] = p.histogram(itemID, scanID, accs[i].ts, prices[i], lo, hi)
+	}
+	writeJSONWithETag(w, r, res)
+}
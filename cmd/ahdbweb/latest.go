@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type latestResponse struct {
+	ItemID  string  `json:"itemId"`
+	Realm   string  `json:"realm"`
+	Faction string  `json:"faction"`
+	Unit    string  `json:"unit"`
+	ScanID  int64   `json:"scanId"`
+	TS      int64   `json:"ts"`
+	TSISO   string  `json:"tsIso"`
+	N       int     `json:"n"` // 0 when the item wasn't listed in that scan
+	Min     float64 `json:"min"`
+	Median  float64 `json:"median"`
+	Max     float64 `json:"max"`
+}
+
+// handleLatest returns an item's prices in the most recent scan of the
+// realm/faction: a light alternative to a series for price tooltips. With
+// maxAgeSec, a latest scan older than that is a 404 rather than stale data.
+func (s *server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	roundMode, err := parseRoundModeParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	unit, priceExpr, err := parseUnitParam(r, roundMode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxAge, err := parseMaxAgeParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "latest")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	res := latestResponse{ItemID: itemID, Realm: realm, Faction: faction, Unit: unit}
+	err = s.db.QueryRowContext(ctx,
+		`SELECT id, UNIX_TIMESTAMP(ts) FROM scanmeta WHERE realm = ? AND faction = ? ORDER BY ts DESC LIMIT 1`,
+		realm, faction,
+	).Scan(&res.ScanID, &res.TS)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "no scans for realm/faction")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if maxAge > 0 && res.TS < time.Now().Unix()-maxAge {
+		writeError(w, http.StatusNotFound, "no scan for realm/faction within maxAgeSec")
+		return
+	}
+	res.TSISO = formatTS(res.TS)
+
+	acc, err := s.loadScan(ctx, "latest", priceExpr, priceFilter(unit, priceExpr, false), res.ScanID, itemID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pt := acc.point(0, false)
+	res.N, res.Min, res.Median, res.Max = pt.N, pt.Min, pt.Median, pt.Max
+	setLastModified(w, res.TS)
+	writeJSONWithETag(w, r, res)
+}
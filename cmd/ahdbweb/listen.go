@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// unixPrefix marks -addr values that are Unix domain socket paths.
+const unixPrefix = "unix:"
+
+// listen opens the listener for addr: a Unix domain socket for
+// unix:/path/to.sock, else a TCP host:port. A socket file left over by a
+// previous run is removed, unless a server still answers on it.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, errors.New("missing unix socket path")
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// Closing the listener on shutdown removes the socket file.
+	return net.Listen("unix", path)
+}
+
+// isUnixPeer reports whether r came in on a Unix domain socket, whose peers
+// are local processes such as the reverse proxy.
+func isUnixPeer(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
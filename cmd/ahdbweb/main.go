@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -13,9 +15,11 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -26,6 +30,27 @@ var embeddedWebFS embed.FS
 
 type server struct {
 	db *sql.DB
+	// readDB serves the query heavy items, series and histogram handlers: a
+	// read replica when configured, else the same as db.
+	readDB  *sql.DB
+	metrics *metrics
+	breaker *breaker
+
+	seriesTimeout    time.Duration
+	histogramTimeout time.Duration
+	// maxResultRows caps the auction rows a series query may read (0: no cap).
+	maxResultRows int64
+	// minSearchLen is the shortest item search query that is run.
+	minSearchLen int
+	// defaultDays is the series range when neither from nor days is given
+	// (0: all history).
+	defaultDays int64
+	// explainSlow is the duration past which series and histogram queries
+	// get their plan logged (0: off).
+	explainSlow time.Duration
+	// known holds the realm and faction names of scanmeta, to match params
+	// case-insensitively.
+	known knownRealms
 }
 
 type realmFaction struct {
@@ -39,50 +64,78 @@ type item struct {
 	ShortID int    `json:"shortId"`
 }
 
-type seriesPoint struct {
-	ScanID int64   `json:"scanId"`
-	TS     int64   `json:"ts"`
-	N      int     `json:"n"`
-	Min    float64 `json:"min"`
-	Q1     float64 `json:"q1"`
-	Q3     float64 `json:"q3"`
-	Max    float64 `json:"max"`
-	Mean   float64 `json:"mean"`
-	Median float64 `json:"median"`
-	Stddev float64 `json:"stddev"`
-}
-
-type seriesResponse struct {
-	Item    item          `json:"item"`
-	Realm   string        `json:"realm"`
-	Faction string        `json:"faction"`
-	Unit    string        `json:"unit"`
-	From    int64         `json:"from"`
-	To      int64         `json:"to"`
-	TrimPct int           `json:"trimPct"`
-	Points  []seriesPoint `json:"points"`
-}
-
-type histogramBin struct {
-	Lo    int64 `json:"lo"`
-	Hi    int64 `json:"hi"`
-	Count int   `json:"count"`
-}
-
-type histogramResponse struct {
-	ItemID  string         `json:"itemId"`
-	ScanID  int64          `json:"scanId"`
-	TS      int64          `json:"ts"`
-	Unit    string         `json:"unit"`
-	TrimPct int            `json:"trimPct"`
-	N       int            `json:"n"`
-	Min     int64          `json:"min"`
-	Max     int64          `json:"max"`
-	Bins    []histogramBin `json:"bins"`
+type itemsPage struct {
+	Total int    `json:"total"`
+	Items []item `json:"items"`
+}
+
+type scanInfo struct {
+	ScanID       int64 `json:"scanId"`
+	TS           int64 `json:"ts"`
+	AuctionCount int   `json:"auctionCount"`
 }
 
 type errorResponse struct {
 	Error string `json:"error"`
+	// Code is one of the code* constants, stable for clients to branch on while
+	// Error is for humans.
+	Code      string            `json:"code"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+}
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	DB      string `json:"db"`
+	Breaker string `json:"breaker,omitempty"` // closed, open or half-open
+	Error   string `json:"error,omitempty"`
+}
+
+// formatCopper formats a copper amount the way the game does, e.g. 158734 as
+// "15g 87s 34c" (100 copper = 1 silver, 100 silver = 1 gold). Zero
+// denominations are left out.
+func formatCopper(copper int64) string {
+	if copper == 0 {
+		return "0c"
+	}
+	sign := ""
+	if copper < 0 {
+		sign = "-"
+		copper = -copper
+	}
+	gold, silver, c := copper/10000, copper/100%100, copper%100
+	var parts []string
+	if gold > 0 {
+		parts = append(parts, strconv.FormatInt(gold, 10)+"g")
+	}
+	if silver > 0 {
+		parts = append(parts, strconv.FormatInt(silver, 10)+"s")
+	}
+	if c > 0 {
+		parts = append(parts, strconv.FormatInt(c, 10)+"c")
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// formatCopperFloat is formatCopper for statistics, rounded to the nearest copper.
+func formatCopperFloat(copper float64) string {
+	return formatCopper(int64(math.Round(copper)))
+}
+
+// formatTS formats Unix seconds as RFC3339 UTC.
+func formatTS(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// splitList splits a comma separated list, dropping blank entries.
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
 }
 
 func getenv(key, fallback string) string {
@@ -110,24 +163,86 @@ func parseConnectionInfo(s string) (net, addr string, _ error) {
 	return net, addr, nil
 }
 
+// mysqlPassword returns the password from the <prefix>PASSWORD_FILE or
+// <prefix>PASSWORD env var, the file taking precedence.
+func mysqlPassword(prefix string) (string, error) {
+	passwd := os.Getenv(prefix + "PASSWORD")
+	if file := os.Getenv(prefix + "PASSWORD_FILE"); file != "" {
+		if passwd != "" {
+			log.Printf("Warning: both %sPASSWORD and %sPASSWORD_FILE are set, using %sPASSWORD_FILE", prefix, prefix, prefix)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("can't read %sPASSWORD_FILE: %w", prefix, err)
+		}
+		passwd = strings.TrimRight(string(b), "\r\n")
+	}
+	return passwd, nil
+}
+
 func mysqlDSN() (string, error) {
-	user := getenv("MYSQL_USER", "root")
-	passwd := os.Getenv("MYSQL_PASSWORD")
-	conn := getenv("MYSQL_CONNECTION_INFO", "tcp(:3306)")
+	return mysqlDSNFor("MYSQL_")
+}
+
+// mysqlReadDSN returns the DSN of the read replica configured with
+// MYSQL_READ_CONNECTION_INFO, or "" when there is none. MYSQL_READ_USER and
+// MYSQL_READ_PASSWORD(_FILE) default to the primary credentials.
+func mysqlReadDSN() (string, error) {
+	if os.Getenv("MYSQL_READ_CONNECTION_INFO") == "" {
+		return "", nil
+	}
+	return mysqlDSNFor("MYSQL_READ_")
+}
+
+// mysqlDSNFor builds the DSN from the connection and credential env vars
+// with the given prefix; the database and driver settings are shared.
+func mysqlDSNFor(prefix string) (string, error) {
+	user := getenv(prefix+"USER", getenv("MYSQL_USER", "root"))
+	credsPrefix := prefix
+	if os.Getenv(prefix+"PASSWORD") == "" && os.Getenv(prefix+"PASSWORD_FILE") == "" {
+		credsPrefix = "MYSQL_"
+	}
+	passwd, err := mysqlPassword(credsPrefix)
+	if err != nil {
+		return "", err
+	}
+	conn := getenv(prefix+"CONNECTION_INFO", "tcp(:3306)")
 	net, addr, err := parseConnectionInfo(conn)
 	if err != nil {
 		return "", err
 	}
+	dbName := strings.TrimSpace(getenv("MYSQL_DATABASE", "ahdb"))
+	if dbName == "" {
+		return "", errors.New("invalid MYSQL_DATABASE (blank)")
+	}
+	charset := strings.TrimSpace(getenv("MYSQL_CHARSET", "utf8mb4"))
+	if charset == "" {
+		return "", errors.New("invalid MYSQL_CHARSET (blank)")
+	}
+	locName := strings.TrimSpace(getenv("MYSQL_LOC", "UTC"))
+	loc, err := time.LoadLocation(locName)
+	if err != nil || locName == "" {
+		return "", fmt.Errorf("invalid MYSQL_LOC %q (expected a time zone name like UTC or Europe/Paris)", locName)
+	}
+	// FROM_UNIXTIME and UNIX_TIMESTAMP convert with the session time zone, so
+	// it is pinned rather than left to the server default, which would shift
+	// the from/to ranges by the server's UTC offset.
+	timeZone := strings.TrimSpace(getenv("MYSQL_TIME_ZONE", "+00:00"))
+	if timeZone == "" || strings.ContainsAny(timeZone, `'"\`) {
+		return "", fmt.Errorf("invalid MYSQL_TIME_ZONE %q (expected an offset like +00:00 or a zone name)", timeZone)
+	}
 	cfg := mysql.NewConfig()
 	cfg.User = user
 	cfg.Passwd = passwd
 	cfg.Net = net
 	cfg.Addr = addr
-	cfg.DBName = "ahdb"
+	cfg.DBName = dbName
+	cfg.Collation = strings.TrimSpace(os.Getenv("MYSQL_COLLATION"))
 	cfg.Params = map[string]string{
-		"charset":   "utf8mb4",
+		"charset":   charset,
 		"parseTime": "true",
-		"loc":       "UTC",
+		"loc":       loc.String(),
+		"time_zone": "'" + timeZone + "'",
 	}
 	cfg.Timeout = 5 * time.Second
 	cfg.ReadTimeout = 30 * time.Second
@@ -141,8 +256,192 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// etagMatches reports whether the If-None-Match header includes etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setLastModified sets the Last-Modified header to ts, the newest scan a
+// response covers, when there is one.
+func setLastModified(w http.ResponseWriter, ts int64) {
+	if ts > 0 {
+		w.Header().Set("Last-Modified", time.Unix(ts, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified reports whether the client already has the response described
+// by the ETag and Last-Modified headers of w. As per RFC 9110 an
+// If-None-Match header takes precedence over If-Modified-Since.
+func notModified(w http.ResponseWriter, r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, w.Header().Get("ETag"))
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// writeJSONWithETag serializes v, tags it with a content hash ETag and replies
+// 304 Not Modified when the client already has that version.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if notModified(w, r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// statusError is an error that should be reported with a specific HTTP status.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// errorStatus returns the status of err if it is a statusError, else fallback.
+func errorStatus(err error, fallback int) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return fallback
+}
+
+// Error codes of errorResponse. Most follow from the status, see statusCode;
+// the more specific ones are set with writeErrorCode.
+const (
+	codeInvalidParam     = "invalid_param"
+	codeValidationFailed = "validation_failed"
+	codeNotFound         = "not_found"
+	codeItemNotFound     = "item_not_found"
+	codeScanNotFound     = "scan_not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeTooLarge         = "too_large"
+	codeRateLimited      = "rate_limited"
+	codeInternal         = "internal_error"
+	codeUnavailable      = "unavailable"
+	codeNoScanData       = "no_scan_data"
+	codeDBUnavailable    = "db_unavailable"
+	codeTooManyQueries   = "too_many_queries"
+)
+
+// statusCode returns the default error code for an HTTP status.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidParam
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return codeTooLarge
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	default:
+		return codeInternal
+	}
+}
+
 func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, errorResponse{Error: msg})
+	writeErrorCode(w, status, statusCode(status), msg)
+}
+
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg, Code: code, RequestID: w.Header().Get(requestIDHeader)})
+}
+
+// fieldErrors collects the validation errors of several query params, keyed
+// by param name.
+type fieldErrors map[string]string
+
+func (fe fieldErrors) Error() string { return "validation failed" }
+
+// add records err, if any, for key and reports whether it did.
+func (fe fieldErrors) add(key string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := fe[key]; !ok {
+		fe[key] = err.Error()
+	}
+	return true
+}
+
+// err returns nil when nothing failed, the plain error when only one param
+// did, and fe itself otherwise.
+func (fe fieldErrors) err() error {
+	switch len(fe) {
+	case 0:
+		return nil
+	case 1:
+		for _, msg := range fe {
+			return errors.New(msg)
+		}
+	}
+	return fe
+}
+
+// writeErrorFor reports err, with per field detail for fieldErrors and the
+// status of a statusError, falling back to the given status.
+func writeErrorFor(w http.ResponseWriter, err error, fallback int) {
+	var fe fieldErrors
+	if errors.As(err, &fe) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fe.Error(), Code: codeValidationFailed, Fields: fe,
+			RequestID: w.Header().Get(requestIDHeader)})
+		return
+	}
+	status := errorStatus(err, fallback)
+	if errors.Is(err, errNoScanData) {
+		writeErrorCode(w, status, codeNoScanData, err.Error())
+		return
+	}
+	writeError(w, status, err.Error())
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	breakerState := s.breaker.currentState()
+	if err := s.db.PingContext(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "degraded", DB: "down", Breaker: breakerState, Error: err.Error()})
+		return
+	}
+	if breakerState == breakerOpen || breakerState == breakerHalfOpen {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "degraded", DB: "up", Breaker: breakerState})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", DB: "up", Breaker: breakerState})
 }
 
 func (s *server) handleRealms(w http.ResponseWriter, r *http.Request) {
@@ -153,6 +452,7 @@ func (s *server) handleRealms(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	done := s.metrics.timeQuery("realms")
 	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT realm, faction FROM scanmeta ORDER BY realm, faction`)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
@@ -173,329 +473,524 @@ func (s *server) handleRealms(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	done()
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (s *server) handleItems(w http.ResponseWriter, r *http.Request) {
+// handleFactions lists the factions scanned on the realm param, for a
+// dependent dropdown. The realm matches whatever its casing; unknown realms
+// get an empty list.
+func (s *server) handleFactions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	q := strings.TrimSpace(r.URL.Query().Get("q"))
-	if q == "" {
-		q = strings.TrimSpace(r.URL.Query().Get("query"))
-	}
-	if len(q) < 2 {
-		writeJSON(w, http.StatusOK, []item{})
+	realm := strings.TrimSpace(r.URL.Query().Get("realm"))
+	if realm == "" {
+		writeError(w, http.StatusBadRequest, "missing realm")
 		return
 	}
-	if len(q) > 64 {
-		q = q[:64]
-	}
-
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, name, shortid FROM items WHERE name LIKE ? ORDER BY name LIMIT 50`,
-		"%"+q+"%",
-	)
+	realm, ok, err := s.canonicalName(ctx, realm, func(k *knownRealms) map[string]string { return k.realms })
+	if errors.Is(err, errNoScanData) || err == nil && !ok {
+		writeJSON(w, http.StatusOK, []string{})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	done := s.metrics.timeQuery("factions")
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT faction FROM scanmeta WHERE realm = ? ORDER BY faction`, realm)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
 
-	res := make([]item, 0, 50)
+	res := []string{}
 	for rows.Next() {
-		var it item
-		if err := rows.Scan(&it.ID, &it.Name, &it.ShortID); err != nil {
+		var faction string
+		if err := rows.Scan(&faction); err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		res = append(res, it)
+		res = append(res, faction)
 	}
 	if err := rows.Err(); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	done()
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (s *server) defaultRealmFaction(ctx context.Context) (realmFaction, error) {
-	var rf realmFaction
-	err := s.db.QueryRowContext(ctx, `SELECT realm, faction FROM scanmeta ORDER BY ts DESC LIMIT 1`).Scan(&rf.Realm, &rf.Faction)
-	if err != nil {
-		return realmFaction{}, err
-	}
-	return rf, nil
+type realmSummary struct {
+	Realm     string `json:"realm"`
+	Faction   string `json:"faction"`
+	LastTS    int64  `json:"lastTs"`
+	ScanCount int64  `json:"scanCount"`
 }
 
-type scanAccumulator struct {
-	scanID int64
-	ts     int64
-	prices []int64
-}
+// handleRealmsSummary lists the realm/faction pairs with their latest scan
+// time and scan count, most recently scanned first.
+func (s *server) handleRealmsSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
 
-func medianSorted(values []int64) float64 {
-	n := len(values)
-	if n == 0 {
-		return 0
+	done := s.metrics.timeQuery("realms_summary")
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT realm, faction, MAX(ts), COUNT(*)
+		FROM scanmeta
+		GROUP BY realm, faction
+		ORDER BY MAX(ts) DESC, realm, faction`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	if n%2 == 1 {
-		return float64(values[n/2])
+	defer rows.Close()
+
+	res := []realmSummary{}
+	for rows.Next() {
+		var rs realmSummary
+		if err := rows.Scan(&rs.Realm, &rs.Faction, &rs.LastTS, &rs.ScanCount); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res = append(res, rs)
 	}
-	lo := values[n/2-1]
-	hi := values[n/2]
-	return float64(lo+hi) / 2
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (a *scanAccumulator) reset(scanID, ts int64) {
-	a.scanID = scanID
-	a.ts = ts
-	a.prices = a.prices[:0]
+// itemByShortID returns the item with the given numeric shortid. Random
+// enchant variants share their base item's shortid, so the base item is
+// preferred, then the lowest id.
+func (s *server) itemByShortID(ctx context.Context, shortID int) (item, error) {
+	var it item
+	err := s.readDB.QueryRowContext(ctx,
+		`SELECT id, name, shortid FROM items WHERE shortid = ? ORDER BY id = CONCAT('i', shortid) DESC, id LIMIT 1`,
+		shortID,
+	).Scan(&it.ID, &it.Name, &it.ShortID)
+	return it, err
 }
 
-func (a *scanAccumulator) add(price int64) {
-	a.prices = append(a.prices, price)
+// itemSortOrders are the allowed sort param values of the item search. The
+// default, relevance, ranks exact matches first, then prefix matches, then
+// any substring match; unknown values fall back to it.
+var itemSortOrders = map[string]string{
+	"relevance": "CASE WHEN name = ? THEN 0 WHEN name LIKE ? THEN 1 ELSE 2 END, name",
+	"name":      "name",
+	"name_desc": "name DESC",
+	"shortid":   "shortid, name",
+	"len":       "CHAR_LENGTH(name), name",
 }
 
-func trimSorted(values []int64, trimPct int) []int64 {
-	if trimPct <= 0 {
-		return values
-	}
-	n := len(values)
-	if n == 0 {
-		return values
-	}
-	trim := int(math.Floor(float64(n) * (float64(trimPct) / 100.0)))
-	maxTrim := (n - 1) / 2
-	if trim > maxTrim {
-		trim = maxTrim
-	}
-	return values[trim : n-trim]
+// itemMatchModes are the allowed match param values of the item search, as
+// the WHERE condition on name and the LIKE patterns it takes for the query.
+// prefix is the one that can use the name index; word matches q at the start
+// of any word of the name.
+var itemMatchModes = map[string]func(q string) (string, []any){
+	"contains": func(q string) (string, []any) { return "name LIKE ?", []any{"%" + q + "%"} },
+	"prefix":   func(q string) (string, []any) { return "name LIKE ?", []any{q + "%"} },
+	"word": func(q string) (string, []any) {
+		return "(name LIKE ? OR name LIKE ?)", []any{q + "%", "% " + q + "%"}
+	},
 }
 
-func (a *scanAccumulator) point(trimPct int) seriesPoint {
-	if len(a.prices) == 0 {
-		return seriesPoint{}
+func (s *server) handleItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
-	prices := trimSorted(a.prices, trimPct)
-	n := len(prices)
-	if n == 0 {
-		return seriesPoint{}
+	if raw := strings.TrimSpace(r.URL.Query().Get("shortId")); raw != "" {
+		shortID, err := strconv.Atoi(raw)
+		if err != nil || shortID <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid shortId")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		it, err := s.itemByShortID(ctx, shortID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusOK, []item{})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, []item{it})
+		return
 	}
 
-	var mean float64
-	var m2 float64
-	for i := range prices {
-		x := float64(prices[i])
-		delta := x - mean
-		mean += delta / float64(i+1)
-		delta2 := x - mean
-		m2 += delta * delta2
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		q = strings.TrimSpace(r.URL.Query().Get("query"))
+	}
+	// Paging params switch the response to the itemsPage wrapper, so plain
+	// searches keep returning a bare array.
+	paged := r.URL.Query().Has("offset") || r.URL.Query().Has("limit")
+	offset, err := parseIntParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := parseIntParam(r, "limit", 50)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	match := strings.TrimSpace(r.URL.Query().Get("match"))
+	if match == "" {
+		match = "contains"
+	}
+	matchCond, ok := itemMatchModes[match]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid match (expected contains|prefix|word)")
+		return
 	}
 
-	minV := float64(prices[0])
-	maxV := float64(prices[n-1])
-	median := medianSorted(prices)
-	q1 := median
-	q3 := median
-	if n > 1 {
-		var lower []int64
-		var upper []int64
-		if n%2 == 0 {
-			lower = prices[:n/2]
-			upper = prices[n/2:]
-		} else {
-			lower = prices[:n/2]
-			upper = prices[n/2+1:]
-		}
-		if len(lower) > 0 {
-			q1 = medianSorted(lower)
-		}
-		if len(upper) > 0 {
-			q3 = medianSorted(upper)
+	if len(q) < s.minSearchLen {
+		if paged {
+			writeJSON(w, http.StatusOK, itemsPage{Items: []item{}})
+			return
 		}
+		writeJSON(w, http.StatusOK, []item{})
+		return
 	}
-	var variance float64
-	if n > 0 {
-		variance = m2 / float64(n)
+	if len(q) > 64 {
+		q = q[:64]
 	}
-	return seriesPoint{
-		ScanID: a.scanID,
-		TS:     a.ts,
-		N:      n,
-		Min:    minV,
-		Q1:     q1,
-		Q3:     q3,
-		Max:    maxV,
-		Mean:   mean,
-		Median: median,
-		Stddev: math.Sqrt(variance),
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	cond, condArgs := matchCond(q)
+	var total int
+	if paged {
+		err := s.readDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM items WHERE `+cond, condArgs...).Scan(&total)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 	}
-}
 
-func parseIntParam(r *http.Request, key string, fallback int64) (int64, error) {
-	raw := strings.TrimSpace(r.URL.Query().Get(key))
-	if raw == "" {
-		return fallback, nil
+	sortBy := strings.TrimSpace(r.URL.Query().Get("sort"))
+	if _, ok := itemSortOrders[sortBy]; !ok {
+		sortBy = "relevance"
 	}
-	v, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid %s", key)
+	args := condArgs
+	if sortBy == "relevance" {
+		args = append(args, q, q+"%")
 	}
-	return v, nil
-}
+	args = append(args, limit, offset)
 
-func parseMaxPointsParam(r *http.Request) (int, error) {
-	raw := strings.TrimSpace(r.URL.Query().Get("maxPoints"))
-	if raw == "" {
-		return 400, nil
-	}
-	v, err := strconv.Atoi(raw)
+	done := s.metrics.timeQuery("items")
+	rows, err := s.readDB.QueryContext(ctx, `
+SELECT id, name, shortid FROM items
+WHERE `+cond+`
+ORDER BY `+itemSortOrders[sortBy]+`
+LIMIT ? OFFSET ?`, args...)
 	if err != nil {
-		return 0, errors.New("invalid maxPoints")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	if v < 10 {
-		return 10, nil
+	defer rows.Close()
+
+	res := make([]item, 0, limit)
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.ID, &it.Name, &it.ShortID); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res = append(res, it)
 	}
-	if v > 5000 {
-		return 5000, nil
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	return v, nil
+	done()
+	if paged {
+		writeJSON(w, http.StatusOK, itemsPage{Total: total, Items: res})
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
 }
 
-func parseTrimPctParam(r *http.Request) (int, error) {
-	raw := strings.TrimSpace(r.URL.Query().Get("trimPct"))
-	if raw == "" {
-		raw = strings.TrimSpace(r.URL.Query().Get("trim"))
+// handleItemByName serves /api/item/by-name?name=..., the single item best
+// matching name, for permalinks: an exact match (names compare case
+// insensitively in the DB collation), preferring the base item over suffixed
+// variants, else the first substring match in alphabetical order.
+func (s *server) handleItemByName(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
-	if raw == "" {
-		return 0, nil
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing name")
+		return
 	}
-	v, err := strconv.Atoi(raw)
-	if err != nil {
-		return 0, errors.New("invalid trimPct")
+	if len(name) > 64 {
+		writeError(w, http.StatusBadRequest, "name too long")
+		return
 	}
-	if v < 0 || v > 50 {
-		return 0, errors.New("trimPct must be between 0 and 50")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	done := s.metrics.timeQuery("item_by_name")
+	var it item
+	err := s.readDB.QueryRowContext(ctx,
+		`SELECT id, name, shortid FROM items WHERE name = ? ORDER BY id = CONCAT('i', shortid) DESC, id LIMIT 1`,
+		name,
+	).Scan(&it.ID, &it.Name, &it.ShortID)
+	if errors.Is(err, sql.ErrNoRows) && len(name) >= s.minSearchLen {
+		err = s.readDB.QueryRowContext(ctx,
+			`SELECT id, name, shortid FROM items WHERE name LIKE ? ORDER BY name, id LIMIT 1`,
+			"%"+name+"%",
+		).Scan(&it.ID, &it.Name, &it.ShortID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+		return
 	}
-	if v%5 != 0 {
-		return 0, errors.New("trimPct must be a multiple of 5")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	return v, nil
+	done()
+	writeJSON(w, http.StatusOK, it)
 }
 
-func parseBinsParam(r *http.Request) (int, error) {
-	raw := strings.TrimSpace(r.URL.Query().Get("bins"))
-	if raw == "" {
-		return 24, nil
+// maxItemsByIDs caps the ids of /api/items/by-ids.
+const maxItemsByIDs = 200
+
+// handleItemsByIDs looks up the items of the comma separated ids param, e.g.
+// a saved watchlist, returning them in the requested order. Unknown ids are
+// left out.
+func (s *server) handleItemsByIDs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
-	v, err := strconv.Atoi(raw)
-	if err != nil {
-		return 0, errors.New("invalid bins")
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range splitList(r.URL.Query().Get("ids")) {
+		if seen[id] {
+			continue
+		}
+		if err := validateItemID(id); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error()+": "+id)
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
 	}
-	if v < 5 {
-		return 5, nil
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, "missing ids")
+		return
 	}
-	if v > 120 {
-		return 120, nil
+	if len(ids) > maxItemsByIDs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many ids (max %d)", maxItemsByIDs))
+		return
 	}
-	return v, nil
-}
 
-func parseScanIDParam(r *http.Request) (int64, error) {
-	raw := strings.TrimSpace(r.URL.Query().Get("scanId"))
-	if raw == "" {
-		return 0, errors.New("missing scanId")
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	items, err := s.loadItems(ctx, ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	v, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil || v <= 0 {
-		return 0, errors.New("invalid scanId")
+	res := make([]item, 0, len(items))
+	for _, id := range ids {
+		if it, ok := items[id]; ok {
+			res = append(res, it)
+		}
 	}
-	return v, nil
+	writeJSON(w, http.StatusOK, res)
 }
 
-func makeHistogram(sortedPrices []int64, bins int) (min int64, max int64, res []histogramBin) {
-	n := len(sortedPrices)
-	if n == 0 {
-		return 0, 0, nil
+type itemSummary struct {
+	Realm   string `json:"realm"`
+	Faction string `json:"faction"`
+	Unit    string `json:"unit"`
+	// Latest holds the stats of the most recent scan listing the item, if any.
+	Latest         *seriesPoint `json:"latest"`
+	ScansLast7Days int          `json:"scansLast7Days"`
+}
+
+type itemDetailResponse struct {
+	Item    item        `json:"item"`
+	Summary itemSummary `json:"summary"`
+}
+
+// handleItemDetail serves /api/item/{id}: the item plus a summary of its
+// latest prices on a realm/faction.
+func (s *server) handleItemDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
-	min = sortedPrices[0]
-	max = sortedPrices[n-1]
-	if min == max {
-		return min, max, []histogramBin{{Lo: min, Hi: max, Count: n}}
+	itemID := strings.TrimSpace(r.PathValue("id"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if bins <= 0 {
-		bins = 24
+	roundMode, err := parseRoundModeParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	unit, priceExpr, err := parseUnitParam(r, roundMode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	rng := max - min
-	width := rng / int64(bins)
-	if rng%int64(bins) != 0 {
-		width++
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "item detail")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
 	}
-	if width < 1 {
-		width = 1
+
+	var it item
+	err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	res = make([]histogramBin, bins)
-	for i := 0; i < bins; i++ {
-		lo := min + int64(i)*width
-		hi := lo + width
-		res[i] = histogramBin{Lo: lo, Hi: hi}
+	summary := itemSummary{Realm: realm, Faction: faction, Unit: unit}
+	since := time.Now().Unix() - 7*86400
+	var latestScanID sql.NullInt64
+	err = s.readDB.QueryRowContext(ctx, `
+SELECT
+  (SELECT a.scanId FROM auctions a JOIN scanmeta s ON s.id = a.scanId
+   WHERE a.itemId = ? AND `+priceFilter(unit, priceExpr, false)+` AND a.itemCount > 0 AND s.realm = ? AND s.faction = ?
+   ORDER BY s.ts DESC LIMIT 1),
+  (SELECT COUNT(DISTINCT a.scanId) FROM auctions a JOIN scanmeta s ON s.id = a.scanId
+   WHERE a.itemId = ? AND s.realm = ? AND s.faction = ? AND s.ts >= FROM_UNIXTIME(?))`,
+		itemID, realm, faction, itemID, realm, faction, since,
+	).Scan(&latestScanID, &summary.ScansLast7Days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	for _, p := range sortedPrices {
-		idx := int((p - min) / width)
-		if idx < 0 {
-			idx = 0
+	if latestScanID.Valid {
+		acc, err := s.loadScan(ctx, "item_detail", priceExpr, priceFilter(unit, priceExpr, false), latestScanID.Int64, itemID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
-		if idx >= bins {
-			idx = bins - 1
+		if len(acc.prices) > 0 {
+			pt := acc.point(0, false)
+			summary.Latest = &pt
 		}
-		res[idx].Count++
 	}
-	return min, max, res
+	writeJSON(w, http.StatusOK, itemDetailResponse{Item: it, Summary: summary})
 }
 
-func (s *server) handleSeries(w http.ResponseWriter, r *http.Request) {
+type itemCoverage struct {
+	ItemID    string `json:"itemId"`
+	Realm     string `json:"realm"`
+	Faction   string `json:"faction"`
+	FirstTS   int64  `json:"firstTs"`
+	LastTS    int64  `json:"lastTs"`
+	ScanCount int    `json:"scanCount"`
+}
+
+// handleItemCoverage reports which scans of a realm/faction list the item.
+// Items without data get zeros rather than a 404.
+func (s *server) handleItemCoverage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-
 	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
-	if itemID == "" {
-		writeError(w, http.StatusBadRequest, "missing itemId")
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	unit := strings.TrimSpace(r.URL.Query().Get("unit"))
-	if unit == "" {
-		unit = "per_item"
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "item coverage")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
 	}
-	var priceExpr string
-	switch unit {
-	case "per_item":
-		priceExpr = "CAST(ROUND(a.buyout / a.itemCount) AS SIGNED)"
-	case "per_stack":
-		priceExpr = "a.buyout"
-	default:
-		writeError(w, http.StatusBadRequest, "invalid unit (expected per_item or per_stack)")
+
+	res := itemCoverage{ItemID: itemID, Realm: realm, Faction: faction}
+	err = s.db.QueryRowContext(ctx, `
+SELECT COALESCE(UNIX_TIMESTAMP(MIN(s.ts)), 0), COALESCE(UNIX_TIMESTAMP(MAX(s.ts)), 0), COUNT(DISTINCT s.id)
+FROM scanmeta s
+JOIN auctions a ON a.scanId = s.id
+WHERE a.itemId = ?
+  AND s.realm = ?
+  AND s.faction = ?`, itemID, realm, faction).Scan(&res.FirstTS, &res.LastTS, &res.ScanCount)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	writeJSON(w, http.StatusOK, res)
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+// errNoScanData is returned by defaultRealmFaction when scanmeta is empty.
+var errNoScanData = errors.New("no scan data loaded yet")
 
-	realm := strings.TrimSpace(r.URL.Query().Get("realm"))
-	faction := strings.TrimSpace(r.URL.Query().Get("faction"))
+// defaultRealmFaction returns the realm and faction of the most recent scan.
+func (s *server) defaultRealmFaction(ctx context.Context) (realmFaction, error) {
+	var rf realmFaction
+	err := s.db.QueryRowContext(ctx, `SELECT realm, faction FROM scanmeta ORDER BY ts DESC LIMIT 1`).Scan(&rf.Realm, &rf.Faction)
+	if errors.Is(err, sql.ErrNoRows) {
+		return realmFaction{}, errNoScanData
+	}
+	if err != nil {
+		return realmFaction{}, err
+	}
+	return rf, nil
+}
+
+// realmFactionParams returns the realm and faction query params, filling in
+// any missing one from the most recent scan. Both are matched
+// case-insensitively and returned as stored in scanmeta.
+func (s *server) realmFactionParams(ctx context.Context, r *http.Request) (realm, faction string, _ error) {
+	realm = strings.TrimSpace(r.URL.Query().Get("realm"))
+	faction = strings.TrimSpace(r.URL.Query().Get("faction"))
 	if realm == "" || faction == "" {
 		rf, err := s.defaultRealmFaction(ctx)
+		if errors.Is(err, errNoScanData) {
+			return "", "", &statusError{http.StatusServiceUnavailable, err}
+		}
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "missing realm/faction and no default available")
-			return
+			return "", "", &statusError{http.StatusInternalServerError, err}
 		}
 		if realm == "" {
 			realm = rf.Realm
@@ -504,231 +999,402 @@ func (s *server) handleSeries(w http.ResponseWriter, r *http.Request) {
 			faction = rf.Faction
 		}
 	}
+	return s.canonicalRealmFaction(ctx, realm, faction)
+}
 
-	now := time.Now().Unix()
-	to, err := parseIntParam(r, "to", now)
+// singleRealmFactionParams is realmFactionParams for the endpoints reading
+// the scans of one realm and faction, which reject the wildcard and both with
+// a 400 naming what, rather than quietly selecting nothing.
+func (s *server) singleRealmFactionParams(ctx context.Context, r *http.Request, what string) (realm, faction string, _ error) {
+	realm, faction, err := s.realmFactionParams(ctx, r)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		return "", "", err
+	}
+	if realm == wildcard || faction == wildcard || faction == factionBoth {
+		return "", "", &statusError{http.StatusBadRequest, fmt.Errorf("wildcards and faction=both are not supported by %s", what)}
+	}
+	return realm, faction, nil
+}
+
+func (s *server) handleScans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	from, err := parseIntParam(r, "from", -1)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "scans")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeErrorFor(w, err, http.StatusBadRequest)
 		return
 	}
-	if from < 0 {
-		days, err := parseIntParam(r, "days", 7)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		if days <= 0 {
-			from = 0
-		} else {
-			from = to - days*86400
-		}
-	}
-	if from > to {
-		writeError(w, http.StatusBadRequest, "from must be <= to")
+	to, err := parseTimeParam(r, "to", time.Now().Unix())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	maxPoints, err := parseMaxPointsParam(r)
+	from, err := parseTimeParam(r, "from", 0)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if from > to {
+		writeError(w, http.StatusBadRequest, "from must be <= to")
+		return
+	}
 
-	trimPct, err := parseTrimPctParam(r)
+	done := s.metrics.timeQuery("scans")
+	rows, err := s.db.QueryContext(ctx, `
+SELECT s.id, UNIX_TIMESTAMP(s.ts) AS ts, COUNT(a.scanId) AS auctionCount
+FROM scanmeta s
+LEFT JOIN auctions a ON a.scanId = s.id
+WHERE s.realm = ?
+  AND s.faction = ?
+  AND s.ts BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)
+GROUP BY s.id, s.ts
+ORDER BY s.ts DESC
+LIMIT 1000`, realm, faction, from, to)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer rows.Close()
 
-	var it item
-	err = s.db.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, "item not found")
+	res := make([]scanInfo, 0, 64)
+	for rows.Next() {
+		var si scanInfo
+		if err := rows.Scan(&si.ScanID, &si.TS, &si.AuctionCount); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		res = append(res, si)
+	}
+	if err := rows.Err(); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	done()
+	writeJSON(w, http.StatusOK, res)
+}
 
-	query := fmt.Sprintf(`
-SELECT a.scanId, UNIX_TIMESTAMP(s.ts) AS ts, %s AS price
-FROM auctions a
-JOIN scanmeta s ON s.id = a.scanId
-WHERE a.itemId = ?
-  AND a.buyout > 0
-  AND a.itemCount > 0
-  AND s.realm = ?
-  AND s.faction = ?
-  AND s.ts BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)
-ORDER BY a.scanId, price`, priceExpr)
+type scanCadence struct {
+	Realm             string  `json:"realm"`
+	Faction           string  `json:"faction"`
+	MedianIntervalSec float64 `json:"medianIntervalSec"`
+	SampleSize        int     `json:"sampleSize"` // number of intervals
+}
 
-	rows, err := s.db.QueryContext(ctx, query, itemID, realm, faction, from, to)
+// handleScanCadence reports the median interval between the last N (scans
+// param, default 50) scans of a realm/faction.
+func (s *server) handleScanCadence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	n, err := parseIntParam(r, "scans", 50)
+	if err != nil || n < 2 || n > 1000 {
+		writeError(w, http.StatusBadRequest, "invalid scans (expected 2..1000)")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "scan cadence")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	done := s.metrics.timeQuery("scan_cadence")
+	rows, err := s.db.QueryContext(ctx, `
+SELECT UNIX_TIMESTAMP(ts)
+FROM scanmeta
+WHERE realm = ? AND faction = ?
+ORDER BY ts DESC
+LIMIT ?`, realm, faction, n)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
 
-	var points []seriesPoint
-	acc := scanAccumulator{prices: make([]int64, 0, 256)}
-	var curScanID int64 = -1
-	var curTS int64
+	var intervals []float64
+	prev := int64(-1)
 	for rows.Next() {
-		var scanID int64
 		var ts int64
-		var price int64
-		if err := rows.Scan(&scanID, &ts, &price); err != nil {
+		if err := rows.Scan(&ts); err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if curScanID == -1 {
-			curScanID = scanID
-			curTS = ts
-			acc.reset(scanID, ts)
+		if prev >= 0 {
+			intervals = append(intervals, float64(prev-ts))
 		}
-		if scanID != curScanID {
-			points = append(points, acc.point(trimPct))
-			curScanID = scanID
-			curTS = ts
-			acc.reset(scanID, ts)
-		}
-		if ts != curTS {
-			acc.ts = ts
-			curTS = ts
-		}
-		acc.add(price)
+		prev = ts
 	}
 	if err := rows.Err(); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if len(acc.prices) > 0 {
-		points = append(points, acc.point(trimPct))
-	}
+	done()
 
-	sort.Slice(points, func(i, j int) bool { return points[i].TS < points[j].TS })
-	if len(points) > maxPoints {
-		points = points[len(points)-maxPoints:]
-	}
-
-	writeJSON(w, http.StatusOK, seriesResponse{
-		Item:    it,
-		Realm:   realm,
-		Faction: faction,
-		Unit:    unit,
-		From:    from,
-		To:      to,
-		TrimPct: trimPct,
-		Points:  points,
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	writeJSON(w, http.StatusOK, scanCadence{
+		Realm:             realm,
+		Faction:           faction,
+		MedianIntervalSec: medianSorted(intervals),
+		SampleSize:        len(intervals),
 	})
 }
 
-func (s *server) handleHistogram(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
+// maxItemIDLen is the width of the items.id column.
+const maxItemIDLen = 32
+
+// validateItemID checks id has the shape of the ids the importer stores:
+// "i" + numeric item id, optionally followed by "?" and a suffix id (e.g.
+// "i15010?25"). Only letters, digits and '?', ':', '-', '_' are accepted.
+func validateItemID(id string) error {
+	if id == "" {
+		return errors.New("missing itemId")
+	}
+	if len(id) > maxItemIDLen {
+		return fmt.Errorf("invalid itemId (longer than %d characters)", maxItemIDLen)
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '?', c == ':', c == '-', c == '_':
+		default:
+			return errors.New("invalid itemId (unexpected character)")
+		}
 	}
+	return nil
+}
 
-	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
-	if itemID == "" {
-		writeError(w, http.StatusBadRequest, "missing itemId")
-		return
+func parseIntParam(r *http.Request, key string, fallback int64) (int64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(key))
+	if raw == "" {
+		return fallback, nil
 	}
-	scanID, err := parseScanIDParam(r)
+	v, err := strconv.ParseInt(raw, 10, 64)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
+		return 0, fmt.Errorf("invalid %s", key)
 	}
+	return v, nil
+}
 
-	unit := strings.TrimSpace(r.URL.Query().Get("unit"))
-	if unit == "" {
-		unit = "per_item"
+// timeParamLayouts are the non-epoch formats parseTimeParam accepts. Dates
+// are taken as UTC midnight.
+var timeParamLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// parseTimeParam parses a Unix epoch seconds timestamp, an ISO date
+// (YYYY-MM-DD) or an RFC3339 time.
+func parseTimeParam(r *http.Request, key string, fallback int64) (int64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(key))
+	if raw == "" {
+		return fallback, nil
 	}
-	var priceExpr string
-	switch unit {
-	case "per_item":
-		priceExpr = "CAST(ROUND(a.buyout / a.itemCount) AS SIGNED)"
-	case "per_stack":
-		priceExpr = "a.buyout"
-	default:
-		writeError(w, http.StatusBadRequest, "invalid unit (expected per_item or per_stack)")
-		return
+	if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return v, nil
+	}
+	for _, layout := range timeParamLayouts {
+		if t, err := time.ParseInLocation(layout, raw, time.UTC); err == nil {
+			return t.Unix(), nil
+		}
 	}
+	return 0, fmt.Errorf("invalid %s, expected epoch seconds, YYYY-MM-DD or RFC3339", key)
+}
 
-	trimPct, err := parseTrimPctParam(r)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
+func parseBoolParam(r *http.Request, key string) (bool, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(key))
+	if raw == "" {
+		return false, nil
 	}
-	bins, err := parseBinsParam(r)
+	v, err := strconv.ParseBool(raw)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
+		return false, fmt.Errorf("invalid %s", key)
 	}
+	return v, nil
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
-	defer cancel()
-
+// loadScan accumulates the prices, sorted, of itemID's auctions in scanID
+// that match the filter condition (see priceFilter). name labels the query in
+// slow query logs.
+func (s *server) loadScan(ctx context.Context, name, priceExpr, filter string, scanID int64, itemID string) (scanAccumulator, error) {
 	query := fmt.Sprintf(`
-SELECT UNIX_TIMESTAMP(s.ts) AS ts, %s AS price
+SELECT UNIX_TIMESTAMP(s.ts) AS ts, %s AS price, a.itemCount
 FROM auctions a
 JOIN scanmeta s ON s.id = a.scanId
 WHERE a.scanId = ?
   AND a.itemId = ?
-  AND a.buyout > 0
+  AND %s
   AND a.itemCount > 0
-ORDER BY price`, priceExpr)
+ORDER BY price`, priceExpr, filter)
 
-	rows, err := s.db.QueryContext(ctx, query, scanID, itemID)
+	defer s.metrics.timeQuery("scan_prices")()
+	defer s.explainIfSlow(s.readDB, name, time.Now(), query, []any{scanID, itemID})
+	acc := scanAccumulator{prices: make([]float64, 0, 256)}
+	acc.reset(scanID, 0)
+	rows, err := s.readDB.QueryContext(ctx, query, scanID, itemID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return acc, err
 	}
 	defer rows.Close()
 
-	var ts int64
-	prices := make([]int64, 0, 256)
 	for rows.Next() {
-		var rowTS int64
-		var price int64
-		if err := rows.Scan(&rowTS, &price); err != nil {
-			writeError(w, http.StatusInternalServerError, err.Error())
-			return
+		var price float64
+		var count int64
+		if err := rows.Scan(&acc.ts, &price, &count); err != nil {
+			return acc, err
 		}
-		ts = rowTS
-		prices = append(prices, price)
-	}
-	if err := rows.Err(); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		acc.add(price, count)
 	}
+	return acc, rows.Err()
+}
 
-	prices = trimSorted(prices, trimPct)
-	minV, maxV, hbins := makeHistogram(prices, bins)
-	writeJSON(w, http.StatusOK, histogramResponse{
-		ItemID:  itemID,
-		ScanID:  scanID,
-		TS:      ts,
-		Unit:    unit,
-		TrimPct: trimPct,
-		N:       len(prices),
-		Min:     minV,
-		Max:     maxV,
-		Bins:    hbins,
-	})
+// waitForDB pings db until it answers, retrying with exponential backoff
+// for up to wait, so the server can start alongside the database.
+func waitForDB(db *sql.DB, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return err
+		}
+		sleep := min(backoff, left)
+		log.Printf("DB not ready (attempt %d): %v, retrying in %v", attempt, err, sleep.Round(time.Millisecond))
+		time.Sleep(sleep)
+		backoff = min(2*backoff, 10*time.Second)
+	}
 }
 
 func main() {
 	var addr string
-	flag.StringVar(&addr, "addr", "127.0.0.1:8080", "listen address")
+	var rate float64
+	var rateBurst int
+	var trustProxy bool
+	var trustedProxiesList string
+	var maxOpenConns, maxIdleConns int
+	var connMaxLifetime time.Duration
+	var enableMetrics bool
+	var cacheTTL, cacheHistoricalTTL time.Duration
+	var cacheSize int
+	var seriesTimeout, histogramTimeout time.Duration
+	var accessLog bool
+	var corsOrigins string
+	var maxResultRows int64
+	var dbWait time.Duration
+	var minSearchLen int
+	var defaultDays int64
+	var idleTimeout, readTimeout, writeTimeout time.Duration
+	var maxHeaderBytes int
+	var tlsCert, tlsKey string
+	var breakerThreshold int
+	var explainQueries bool
+	var spaFallback bool
+	var maxConcurrentQueries int
+	var queryWait time.Duration
+	var slowQuery time.Duration
+	var breakerWindow, breakerCooldown time.Duration
+	var shutdownTimeout time.Duration
+	flag.StringVar(&addr, "addr", "127.0.0.1:8080", "listen address, host:port or unix:/path/to.sock (add unix to -trusted-proxies to believe X-Forwarded-For from the socket's peers)")
+	flag.Float64Var(&rate, "rate", 0, "per client IP API requests per second (0 disables rate limiting)")
+	flag.IntVar(&rateBurst, "rate-burst", 20, "per client IP API request burst size")
+	flag.BoolVar(&trustProxy, "trust-proxy", false, "trust X-Forwarded-For from any peer (same as -trusted-proxies 0.0.0.0/0,::/0,unix)")
+	flag.StringVar(&trustedProxiesList, "trusted-proxies", "", "comma separated CIDRs or IPs of proxies whose X-Forwarded-For/X-Real-IP give the client IP; unix trusts peers on a unix: -addr socket")
+	flag.IntVar(&maxOpenConns, "max-open-conns", 10, "maximum number of open DB connections")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", 10, "maximum number of idle DB connections (<= max-open-conns)")
+	flag.DurationVar(&connMaxLifetime, "conn-max-lifetime", 5*time.Minute, "maximum lifetime of a DB connection")
+	flag.BoolVar(&enableMetrics, "metrics", false, "expose Prometheus metrics on /metrics")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 60*time.Second, "series response cache TTL (0 disables the cache)")
+	flag.DurationVar(&cacheHistoricalTTL, "cache-ttl-historical", 10*time.Minute, "series response cache TTL for ranges ending over an hour ago")
+	flag.IntVar(&cacheSize, "cache-size", 256, "maximum number of cached series responses (0 disables the cache)")
+	flag.DurationVar(&seriesTimeout, "series-timeout", 30*time.Second, "DB timeout for series requests")
+	flag.DurationVar(&histogramTimeout, "histogram-timeout", 15*time.Second, "DB timeout for histogram requests")
+	flag.BoolVar(&accessLog, "access-log", true, "log each API request")
+	flag.StringVar(&corsOrigins, "cors-origins", "", "comma separated origins allowed to call the API cross-origin, or \"*\" for any")
+	flag.Int64Var(&maxResultRows, "max-result-rows", 2000000, "maximum auction rows a series request may read before failing with 413 (0 disables the limit)")
+	flag.DurationVar(&dbWait, "db-wait", 30*time.Second, "how long to keep retrying the DB at startup before giving up (0 tries once)")
+	flag.IntVar(&minSearchLen, "min-search-len", 2, "minimum item search query length (shorter queries return no items)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 120*time.Second, "how long idle keep-alive connections are kept open")
+	flag.DurationVar(&readTimeout, "read-timeout", 15*time.Second, "maximum time to read a whole request (0 for none)")
+	flag.DurationVar(&writeTimeout, "write-timeout", 60*time.Second, "maximum time to write a response, keep above -series-timeout (0 for none)")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", 64<<10, "maximum size of request headers")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file, to serve HTTPS (with -tls-key)")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key file, to serve HTTPS (with -tls-cert)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to let in-flight requests finish on SIGINT/SIGTERM")
+	flag.Int64Var(&defaultDays, "default-days", 7, "days of history series return when the request has no from or days (0 for all)")
+	flag.IntVar(&breakerThreshold, "breaker-threshold", 5, "consecutive failed DB-backed API requests that open the DB circuit breaker (0 disables it)")
+	flag.DurationVar(&breakerWindow, "breaker-window", 30*time.Second, "maximum time between failures counted as consecutive")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 15*time.Second, "how long the open circuit breaker fails requests before probing the DB")
+	flag.IntVar(&maxConcurrentQueries, "max-concurrent-queries", 0, "maximum concurrent series and histogram requests (0: unlimited)")
+	flag.DurationVar(&queryWait, "query-wait", time.Second, "how long a request waits for a -max-concurrent-queries slot before a 503")
+	flag.BoolVar(&spaFallback, "spa-fallback", false, "serve index.html for unknown non-API paths without a file extension (client side routing)")
+	flag.BoolVar(&explainQueries, "explain", false, "log the EXPLAIN plan of slow series and histogram queries")
+	flag.DurationVar(&slowQuery, "slow-query", 2*time.Second, "query duration past which -explain logs the plan")
 	flag.Parse()
 
+	if maxOpenConns < 1 {
+		log.Fatalf("invalid -max-open-conns %d (must be >= 1)", maxOpenConns)
+	}
+	if maxIdleConns < 0 || maxIdleConns > maxOpenConns {
+		log.Fatalf("invalid -max-idle-conns %d (must be between 0 and -max-open-conns %d)", maxIdleConns, maxOpenConns)
+	}
+	if seriesTimeout <= 0 {
+		log.Fatalf("invalid -series-timeout %v (must be positive)", seriesTimeout)
+	}
+	if histogramTimeout <= 0 {
+		log.Fatalf("invalid -histogram-timeout %v (must be positive)", histogramTimeout)
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be set together")
+	}
+	if idleTimeout < 0 || readTimeout < 0 || writeTimeout < 0 {
+		log.Fatalf("invalid -idle-timeout, -read-timeout or -write-timeout (must be >= 0)")
+	}
+	if writeTimeout > 0 && writeTimeout <= seriesTimeout {
+		log.Printf("warning: -write-timeout %v is not above -series-timeout %v, slow series responses will be cut off", writeTimeout, seriesTimeout)
+	}
+	if maxHeaderBytes < 1024 {
+		log.Fatalf("invalid -max-header-bytes %d (must be >= 1024)", maxHeaderBytes)
+	}
+	if defaultDays < 0 {
+		log.Fatalf("invalid -default-days %d (must be >= 0)", defaultDays)
+	}
+	if minSearchLen < 1 {
+		log.Fatalf("invalid -min-search-len %d (must be >= 1)", minSearchLen)
+	}
+	if trustProxy {
+		trustedProxiesList += ",0.0.0.0/0,::/0,unix"
+	}
+	trustedProxies, err := parseTrustedProxies(splitList(trustedProxiesList))
+	if err != nil {
+		log.Fatalf("invalid -trusted-proxies: %v", err)
+	}
+	if maxConcurrentQueries < 0 || queryWait < 0 {
+		log.Fatalf("invalid -max-concurrent-queries %d or -query-wait %v (must be >= 0)", maxConcurrentQueries, queryWait)
+	}
+	if slowQuery <= 0 {
+		log.Fatalf("invalid -slow-query %v (must be positive)", slowQuery)
+	}
+	if breakerThreshold < 0 || breakerWindow <= 0 || breakerCooldown <= 0 {
+		log.Fatalf("invalid -breaker-threshold, -breaker-window or -breaker-cooldown (threshold must be >= 0, durations positive)")
+	}
+	if dbWait < 0 {
+		log.Fatalf("invalid -db-wait %v (must be >= 0)", dbWait)
+	}
+	if maxResultRows < 0 {
+		log.Fatalf("invalid -max-result-rows %d (must be >= 0)", maxResultRows)
+	}
+
 	dsn, err := mysqlDSN()
 	if err != nil {
 		log.Fatalf("DB config error: %v", err)
@@ -737,36 +1403,166 @@ func main() {
 	if err != nil {
 		log.Fatalf("DB open error: %v", err)
 	}
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(10)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
 	defer db.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
+	if err := waitForDB(db, dbWait); err != nil {
 		log.Fatalf("DB ping error: %v", err)
 	}
 
+	readDB := db
+	readDSN, err := mysqlReadDSN()
+	if err != nil {
+		log.Fatalf("DB read replica config error: %v", err)
+	}
+	if readDSN != "" {
+		readDB, err = sql.Open("mysql", readDSN)
+		if err != nil {
+			log.Fatalf("DB read replica open error: %v", err)
+		}
+		readDB.SetMaxOpenConns(maxOpenConns)
+		readDB.SetMaxIdleConns(maxIdleConns)
+		readDB.SetConnMaxLifetime(connMaxLifetime)
+		defer readDB.Close()
+		if err := waitForDB(readDB, dbWait); err != nil {
+			log.Fatalf("DB read replica ping error: %v", err)
+		}
+		log.Printf("Using the read replica for items, series and histogram queries")
+	}
+
 	webFS, err := fs.Sub(embeddedWebFS, "web")
 	if err != nil {
 		log.Fatalf("web assets error: %v", err)
 	}
 
-	s := &server{db: db}
+	s := &server{db: db, readDB: readDB, seriesTimeout: seriesTimeout, histogramTimeout: histogramTimeout,
+		maxResultRows: maxResultRows, minSearchLen: minSearchLen, defaultDays: defaultDays}
+	if explainQueries {
+		s.explainSlow = slowQuery
+	}
+	if enableMetrics {
+		s.metrics = newMetrics()
+		stop := make(chan struct{})
+		defer close(stop)
+		s.metrics.watchDB("primary", db, 10*time.Second, stop)
+		if readDB != db {
+			s.metrics.watchDB("read", readDB, 10*time.Second, stop)
+		}
+	}
+
+	var limiter *queryLimiter
+	if maxConcurrentQueries > 0 {
+		limiter = newQueryLimiter(maxConcurrentQueries, queryWait)
+	}
+
+	api := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		api.Handle(pattern, s.metrics.instrument(pattern, h))
+	}
+	if breakerThreshold > 0 {
+		s.breaker = newBreaker(breakerThreshold, breakerWindow, breakerCooldown)
+	}
+	// withDB wraps the DB-backed handlers with the breaker, innermost so that
+	// cache hits and limiter rejections don't count.
+	withDB := s.breaker.handler
+	handle("/api/healthz", s.handleHealthz)
+	handle("/api/version", s.handleVersion)
+	handle("/api/openapi.json", s.handleOpenAPI)
+	handle("/api/stats", newResponseCache(statsCacheTTL, statsCacheTTL, 4).handler(withDB(s.handleStats)))
+	handle("/api/realms", withDB(s.handleRealms))
+	handle("/api/realms/summary", withDB(s.handleRealmsSummary))
+	handle("/api/realms/compare", limiter.handler(withDB(s.handleRealmCompare)))
+	handle("/api/factions", withDB(s.handleFactions))
+	handle("/api/items", withDB(s.handleItems))
+	handle("/api/items/top", withDB(s.handleTopItems))
+	handle("/api/items/recent", withDB(s.handleRecentItems))
+	handle("/api/items/by-ids", withDB(s.handleItemsByIDs))
+	handle("/api/portfolio", limiter.handler(withDB(s.handlePortfolio)))
+	handle("/api/search", withDB(s.handleSearch))
+	handle("/api/item/{id}", withDB(s.handleItemDetail))
+	handle("/api/item/coverage", withDB(s.handleItemCoverage))
+	handle("/api/item/by-name", withDB(s.handleItemByName))
+	handle("/api/latest", withDB(s.handleLatest))
+	handle("/api/scans", withDB(s.handleScans))
+	handle("/api/auctions", withDB(s.handleAuctions))
+	handle("/api/scans/cadence", withDB(s.handleScanCadence))
+	handle("/api/scans/export", limiter.handler(withDB(s.handleScanExport)))
+	// Cache hits don't take a limiter slot.
+	seriesHandler, seriesMultiHandler := limiter.handler(withDB(s.handleSeries)), limiter.handler(withDB(s.handleSeriesMulti))
+	if cacheTTL > 0 && cacheSize > 0 {
+		cache := newResponseCache(cacheTTL, cacheHistoricalTTL, cacheSize)
+		seriesHandler, seriesMultiHandler = cache.handler(seriesHandler), cache.handler(seriesMultiHandler)
+	}
+	handle("/api/series", seriesHandler)
+	handle("/api/series/multi", seriesMultiHandler)
+	handle("/api/series/resample", limiter.handler(withDB(s.handleSeriesResample)))
+	handle("/api/series/ohlc", limiter.handler(withDB(s.handleOHLC)))
+	handle("/api/series/seasonality", limiter.handler(withDB(s.handleSeasonality)))
+	handle("/api/histogram", limiter.handler(withDB(s.handleHistogram)))
+	handle("/api/histogram/compare", limiter.handler(withDB(s.handleHistogramCompare)))
+	handle("/api/histogram/batch", limiter.handler(withDB(s.handleHistogramBatch)))
+
+	var apiHandler http.Handler = prettyHandler(api)
+	if rate > 0 {
+		apiHandler = newRateLimiter(rate, rateBurst, trustedProxies).handler(apiHandler)
+	}
+	apiHandler = timingHandler(gzipHandler(apiHandler))
+	if accessLog {
+		apiHandler = logHandler(trustedProxies, apiHandler)
+	}
+	if origins := splitList(corsOrigins); len(origins) > 0 {
+		apiHandler = corsHandler(origins, apiHandler)
+	}
+	apiHandler = requestIDHandler(apiHandler)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/realms", s.handleRealms)
-	mux.HandleFunc("/api/items", s.handleItems)
-	mux.HandleFunc("/api/series", s.handleSeries)
-	mux.HandleFunc("/api/histogram", s.handleHistogram)
-	mux.Handle("/", http.FileServer(http.FS(webFS)))
+	mux.Handle("/api/", apiHandler)
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics.handler())
+	}
+	mux.Handle("/", staticHandler(webFS, spaFallback))
 
 	httpServer := &http.Server{
 		Addr:              addr,
 		Handler:           mux,
 		ReadHeaderTimeout: 5 * time.Second,
-	}
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+
+	// On SIGINT/SIGTERM, stop accepting connections and let in-flight
+	// requests finish before the deferred cleanups run.
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-sigCtx.Done()
+		log.Printf("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+	}()
 
-	log.Printf("Listening on http://%s", addr)
-	log.Fatal(httpServer.ListenAndServe())
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsCert != "" {
+		log.Printf("Listening on https://%s", addr)
+		err = httpServer.ServeTLS(ln, tlsCert, tlsKey)
+	} else {
+		log.Printf("Listening on http://%s", addr)
+		err = httpServer.Serve(ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-shutdownDone
 }
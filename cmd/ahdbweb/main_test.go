@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDSNTimeZone(t *testing.T) {
+	tests := []struct {
+		name         string
+		loc          string
+		timeZone     string
+		wantLoc      string
+		wantTimeZone string
+		wantErr      bool
+	}{
+		{name: "defaults", wantLoc: "UTC", wantTimeZone: "'+00:00'"},
+		{name: "explicit offset", timeZone: "-05:00", wantLoc: "UTC", wantTimeZone: "'-05:00'"},
+		{name: "explicit zone and loc", loc: "Europe/Paris", timeZone: "Europe/Paris", wantLoc: "Europe/Paris", wantTimeZone: "'Europe/Paris'"},
+		{name: "quote in time zone", timeZone: "+00:00'", wantErr: true},
+		{name: "unknown loc", loc: "Nowhere/Special", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"MYSQL_USER", "MYSQL_PASSWORD", "MYSQL_PASSWORD_FILE",
+				"MYSQL_CONNECTION_INFO", "MYSQL_DATABASE", "MYSQL_CHARSET", "MYSQL_COLLATION"} {
+				t.Setenv(key, "")
+			}
+			t.Setenv("MYSQL_LOC", tt.loc)
+			t.Setenv("MYSQL_TIME_ZONE", tt.timeZone)
+
+			dsn, err := mysqlDSN()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("mysqlDSN() = %q, want an error", dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mysqlDSN() error: %v", err)
+			}
+			cfg, err := mysql.ParseDSN(dsn)
+			if err != nil {
+				t.Fatalf("ParseDSN(%q): %v", dsn, err)
+			}
+			if got := cfg.Loc.String(); got != tt.wantLoc {
+				t.Errorf("loc = %q, want %q", got, tt.wantLoc)
+			}
+			if got := cfg.Params["time_zone"]; got != tt.wantTimeZone {
+				t.Errorf("time_zone = %q, want %q", got, tt.wantTimeZone)
+			}
+			if !cfg.ParseTime {
+				t.Error("parseTime is off")
+			}
+		})
+	}
+}
+
+func TestValidateItemID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr string
+	}{
+		{"i15010", ""},
+		{"i15010?25", ""},
+		{"i2:3-4_5", ""},
+		{strings.Repeat("1", maxItemIDLen), ""},
+		{"", "missing itemId"},
+		{strings.Repeat("1", maxItemIDLen+1), "longer than"},
+		{"i15010 OR 1=1", "unexpected character"},
+		{"i1;DROP", "unexpected character"},
+		{"i1%", "unexpected character"},
+		{"i1'", "unexpected character"},
+		{"ïtem", "unexpected character"},
+	}
+	for _, tt := range tests {
+		err := validateItemID(tt.id)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("validateItemID(%q) = %v, want nil", tt.id, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("validateItemID(%q) = %v, want an error containing %q", tt.id, err, tt.wantErr)
+		}
+	}
+}
+
+// TestItemsRelevanceArgs checks the relevance sort gets the exact name and
+// the prefix pattern it ranks first and second.
+func TestItemsRelevanceArgs(t *testing.T) {
+	var gotQuery string
+	var gotArgs []any
+	db := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		gotQuery = query
+		for _, a := range args {
+			gotArgs = append(gotArgs, a.Value)
+		}
+		return fakeResult{columns: []string{"id", "name", "shortid"}}
+	})
+	s := &server{db: db, readDB: db, minSearchLen: 2}
+	w := httptest.NewRecorder()
+	s.handleItems(w, httptest.NewRequest(http.MethodGet, "/api/items?q=Healing+Potion", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(gotQuery, itemSortOrders["relevance"]) {
+		t.Errorf("query doesn't order by relevance:\n%s", gotQuery)
+	}
+	want := []any{"%Healing Potion%", "Healing Potion", "Healing Potion%", int64(50), int64(0)}
+	if !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %v, want %v", gotArgs, want)
+	}
+}
+
+// sqlLike reports whether s matches the LIKE pattern, case-insensitively as
+// with MySQL's default collation.
+func sqlLike(s, pattern any) bool {
+	re := "(?is)^" + strings.NewReplacer("%", ".*", "_", ".").Replace(regexp.QuoteMeta(pattern.(string))) + "$"
+	return regexp.MustCompile(re).MatchString(s.(string))
+}
+
+// TestItemsRelevanceOrder runs the relevance search on a fake DB evaluating
+// its WHERE and ORDER BY with the bound args, the way MySQL would.
+func TestItemsRelevanceOrder(t *testing.T) {
+	names := []string{"Greater Healing Potion", "Healing Potion of Doom", "Minor Healing Potion", "Healing Potion", "Mana Potion"}
+	db := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if !strings.Contains(query, "WHERE name LIKE ?") || !strings.Contains(query, "ORDER BY "+itemSortOrders["relevance"]) || len(args) < 3 {
+			return fakeResult{err: fmt.Errorf("unexpected query %q with %d args", query, len(args))}
+		}
+		pattern, exact, prefix := args[0].Value, args[1].Value, args[2].Value
+		rank := func(name string) int {
+			switch {
+			case strings.EqualFold(name, exact.(string)):
+				return 0
+			case sqlLike(name, prefix):
+				return 1
+			}
+			return 2
+		}
+		var matches []string
+		for _, name := range names {
+			if sqlLike(name, pattern) {
+				matches = append(matches, name)
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if ri, rj := rank(matches[i]), rank(matches[j]); ri != rj {
+				return ri < rj
+			}
+			return matches[i] < matches[j]
+		})
+		res := fakeResult{columns: []string{"id", "name", "shortid"}}
+		for i, name := range matches {
+			res.rows = append(res.rows, []driver.Value{fmt.Sprint("i", i), name, int64(i)})
+		}
+		return res
+	})
+	s := &server{db: db, readDB: db, minSearchLen: 2}
+	w := httptest.NewRecorder()
+	s.handleItems(w, httptest.NewRequest(http.MethodGet, "/api/items?q=healing+potion", nil))
+	var res []item
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	var got []string
+	for _, it := range res {
+		got = append(got, it.Name)
+	}
+	// The exact match, then the prefix match, then mid-string ones by name.
+	want := []string{"Healing Potion", "Healing Potion of Doom", "Greater Healing Potion", "Minor Healing Potion"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestItemsRelevanceMySQL runs the relevance search against the MySQL server
+// of AHDB_TEST_MYSQL_DSN, on a temporary items table so no data is touched.
+func TestItemsRelevanceMySQL(t *testing.T) {
+	dsn := os.Getenv("AHDB_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("AHDB_TEST_MYSQL_DSN not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Temporary tables are per connection, so stick to one.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TEMPORARY TABLE items (id VARCHAR(32) NOT NULL, shortid INT NOT NULL, name VARCHAR(128) NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"Greater Healing Potion", "Healing Potion of Doom", "Minor Healing Potion", "Healing Potion", "Mana Potion"}
+	for i, name := range names {
+		if _, err := db.Exec(`INSERT INTO items (id, shortid, name) VALUES (?, ?, ?)`, "i"+strings.Repeat("1", i+1), i+1, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &server{db: db, readDB: db, minSearchLen: 2}
+	w := httptest.NewRecorder()
+	s.handleItems(w, httptest.NewRequest(http.MethodGet, "/api/items?q=Healing+Potion", nil))
+	var res []item
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	var got []string
+	for _, it := range res {
+		got = append(got, it.Name)
+	}
+	// The exact match, then the prefix match, then mid-string ones by name.
+	want := []string{"Healing Potion", "Healing Potion of Doom", "Greater Healing Potion", "Minor Healing Potion"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatCopper(t *testing.T) {
+	tests := []struct {
+		copper int64
+		want   string
+	}{
+		{0, "0c"},
+		{7, "7c"},
+		{99, "99c"},
+		{100, "1s"},
+		{1234, "12s 34c"},
+		{10000, "1g"},
+		{10005, "1g 5c"},
+		{150000, "15g"},
+		{158734, "15g 87s 34c"},
+		{1234567890, "123456g 78s 90c"},
+		{-158734, "-15g 87s 34c"},
+	}
+	for _, tt := range tests {
+		if got := formatCopper(tt.copper); got != tt.want {
+			t.Errorf("formatCopper(%d) = %q, want %q", tt.copper, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyDBIsNoScanData(t *testing.T) {
+	db := newFakeDB(t, fakeRowsFor("FROM scanmeta", fakeResult{columns: []string{"realm", "faction"}}))
+	s := &server{db: db, readDB: db}
+	for _, target := range []string{"/api/scans", "/api/scans?realm=Stormrage&faction=Horde"} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleScans(w, httptest.NewRequest(http.MethodGet, target, nil))
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503: %s", w.Code, w.Body)
+			}
+			var res errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != codeNoScanData {
+				t.Errorf("code = %q, want %q", res.Code, codeNoScanData)
+			}
+		})
+	}
+}
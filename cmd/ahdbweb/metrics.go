@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors. A nil *metrics is valid and
+// records nothing, which is what the server uses when -metrics is off.
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	dbConns         *prometheus.GaugeVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ahdbweb_http_requests_total",
+			Help: "API requests by handler and status code.",
+		}, []string{"handler", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ahdbweb_http_request_duration_seconds",
+			Help:    "API request latency by handler.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"handler"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ahdbweb_db_query_duration_seconds",
+			Help:    "DB query duration (including reading the rows) by query.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"query"}),
+		dbConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ahdbweb_db_connections",
+			Help: "DB pool connections by pool (primary, read) and state (open, in_use, idle).",
+		}, []string{"db", "state"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.queryDuration, m.dbConns,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// watchDB exports the stats of the pool db under the given db label: its
+// wait count, read at scrape time as it is cumulative, and its connections,
+// copied into the pool gauges every interval until stop is closed.
+func (m *metrics) watchDB(name string, db *sql.DB, interval time.Duration, stop <-chan struct{}) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "ahdbweb_db_wait_count_total",
+		Help:        "Total number of times a connection had to be waited for, by pool.",
+		ConstLabels: prometheus.Labels{"db": name},
+	}, func() float64 { return float64(db.Stats().WaitCount) }))
+	go m.publishDBStats(name, db, interval, stop)
+}
+
+func (m *metrics) publishDBStats(name string, db *sql.DB, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		st := db.Stats()
+		m.dbConns.WithLabelValues(name, "open").Set(float64(st.OpenConnections))
+		m.dbConns.WithLabelValues(name, "in_use").Set(float64(st.InUse))
+		m.dbConns.WithLabelValues(name, "idle").Set(float64(st.Idle))
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// timeQuery starts timing the named query; call the returned func when done.
+func (m *metrics) timeQuery(name string) func() {
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		m.queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// instrument counts and times requests to h under the given handler name.
+func (m *metrics) instrument(name string, h http.HandlerFunc) http.Handler {
+	if m == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.requestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(name, strconv.Itoa(rec.status)).Inc()
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchDBPools(t *testing.T) {
+	m := newMetrics()
+	stop := make(chan struct{})
+	defer close(stop)
+	m.watchDB("primary", newFakeDB(t, fakeRowsFor("", fakeResult{})), time.Hour, stop)
+	m.watchDB("read", newFakeDB(t, fakeRowsFor("", fakeResult{})), time.Hour, stop)
+
+	mfs, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "ahdbweb_db_wait_count_total" {
+			continue
+		}
+		if mf.GetType().String() != "COUNTER" {
+			t.Errorf("type = %v, want counter", mf.GetType())
+		}
+		pools := map[string]bool{}
+		for _, metric := range mf.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "db" {
+					pools[l.GetValue()] = true
+				}
+			}
+		}
+		if !pools["primary"] || !pools["read"] {
+			t.Errorf("db labels = %v, want primary and read", pools)
+		}
+		return
+	}
+	t.Error("ahdbweb_db_wait_count_total not exported")
+}
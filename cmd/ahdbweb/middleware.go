@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gzipMinSize is the response size below which compression isn't worth it.
+const gzipMinSize = 1024
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the first gzipMinSize bytes of the response and
+// only switches to gzip once the body is known to be larger than that.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	started bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.started {
+		return
+	}
+	g.status = status
+}
+
+func (g *gzipResponseWriter) start(compress bool) {
+	g.started = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if compress {
+		h := g.ResponseWriter.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.started {
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < gzipMinSize {
+			return len(p), nil
+		}
+		g.start(true)
+		buf := g.buf
+		g.buf = nil
+		if _, err := g.gz.Write(buf); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush sends what was written so far, compressed, to the client. Flushing
+// means the response is being streamed, so it is compressed even if small.
+func (g *gzipResponseWriter) Flush() {
+	if !g.started {
+		g.start(true)
+		buf := g.buf
+		g.buf = nil
+		_, _ = g.gz.Write(buf)
+	}
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	_ = http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// finish flushes whatever is still buffered, uncompressed if it stayed small.
+func (g *gzipResponseWriter) finish() {
+	if !g.started {
+		g.start(false)
+		if len(g.buf) > 0 {
+			_, _ = g.ResponseWriter.Write(g.buf)
+		}
+		return
+	}
+	if g.gz != nil {
+		_ = g.gz.Close()
+	}
+}
+
+// gzipHandler compresses responses for clients that accept gzip.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// prettyWriter buffers a JSON response so it can be indented once complete.
+// Other content types are passed straight through.
+type prettyWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (p *prettyWriter) WriteHeader(status int) {
+	if p.status != 0 {
+		return
+	}
+	p.status = status
+	if !strings.HasPrefix(p.Header().Get("Content-Type"), "application/json") {
+		p.passthrough = true
+		p.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.WriteHeader(http.StatusOK)
+	}
+	if p.passthrough {
+		return p.ResponseWriter.Write(b)
+	}
+	return p.buf.Write(b)
+}
+
+func (p *prettyWriter) finish() {
+	if p.status == 0 || p.passthrough {
+		return
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, p.buf.Bytes(), "", "  "); err != nil {
+		out = p.buf
+	}
+	p.Header().Del("Content-Length")
+	// The ETag names the compact body, which this one no longer is byte for
+	// byte.
+	p.Header().Del("ETag")
+	p.ResponseWriter.WriteHeader(p.status)
+	_, _ = p.ResponseWriter.Write(out.Bytes())
+}
+
+// Flush passes through to the client for non-JSON responses. JSON ones are
+// buffered until finish regardless.
+func (p *prettyWriter) Flush() {
+	if p.passthrough {
+		_ = http.NewResponseController(p.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (p *prettyWriter) Unwrap() http.ResponseWriter { return p.ResponseWriter }
+
+// prettyHandler indents JSON responses for requests with pretty=true, which
+// is handy with curl. Responses stay compact by default.
+func prettyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); !pretty {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &prettyWriter{ResponseWriter: w}
+		defer pw.finish()
+		next.ServeHTTP(pw, r)
+	})
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client-IP token bucket limiter.
+type rateLimiter struct {
+	rate           float64 // tokens per second
+	burst          float64
+	trustedProxies proxySet
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int, trustedProxies proxySet) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:           rate,
+		burst:          float64(burst),
+		trustedProxies: trustedProxies,
+		buckets:        make(map[string]*tokenBucket),
+		lastSweep:      time.Now(),
+	}
+}
+
+// allow takes a token for key, returning how long to wait when none is left.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		// Buckets idle long enough to be full again carry no state.
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// proxySet is the parsed -trusted-proxies list.
+type proxySet struct {
+	prefixes []netip.Prefix
+	// unix trusts every peer on the -addr unix: socket.
+	unix bool
+}
+
+// parseTrustedProxies parses the -trusted-proxies list of CIDRs, bare IPs
+// and the unix token.
+func parseTrustedProxies(list []string) (proxySet, error) {
+	var res proxySet
+	for _, s := range list {
+		if s == "unix" {
+			res.unix = true
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			res.prefixes = append(res.prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return proxySet{}, fmt.Errorf("invalid trusted proxy %q (expected a CIDR, IP or unix)", s)
+		}
+		res.prefixes = append(res.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return res, nil
+}
+
+func isTrustedProxy(ip string, trusted proxySet) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client behind r. X-Forwarded-For and
+// X-Real-IP are only believed when the direct peer is a trusted proxy; the
+// client is then the rightmost X-Forwarded-For entry that isn't itself a
+// trusted proxy, as entries left of it may be spoofed. Peers on a Unix
+// socket are trusted only with the unix token.
+func clientIP(r *http.Request, trusted proxySet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(peer, trusted) && !(trusted.unix && isUnixPeer(r)) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if i == 0 || !isTrustedProxy(hop, trusted) {
+				return hop
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return peer
+}
+
+func (l *rateLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientIP(r, l.trustedProxies), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// queryLimiter caps the number of expensive handlers running at once, so a
+// few huge queries can't take up the whole DB connection pool. A nil
+// *queryLimiter doesn't limit.
+type queryLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newQueryLimiter(n int, wait time.Duration) *queryLimiter {
+	return &queryLimiter{slots: make(chan struct{}, n), wait: wait}
+}
+
+// handler runs h once a slot is free, waiting up to l.wait for one before
+// giving up with a 503.
+func (l *queryLimiter) handler(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Take a free slot at once, so a zero wait doesn't race the timer.
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(l.wait)
+			defer timer.Stop()
+			select {
+			case l.slots <- struct{}{}:
+			case <-timer.C:
+				w.Header().Set("Retry-After", "1")
+				writeErrorCode(w, http.StatusServiceUnavailable, codeTooManyQueries, "too many concurrent queries, try again later")
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		defer func() { <-l.slots }()
+		h(w, r)
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// responseTimeHeader reports how long the handler took before responding.
+const responseTimeHeader = "X-Response-Time-Ms"
+
+// timingWriter sets responseTimeHeader when the response header is sent.
+type timingWriter struct {
+	http.ResponseWriter
+	start time.Time
+	sent  bool
+}
+
+func (t *timingWriter) stamp() {
+	if t.sent {
+		return
+	}
+	t.sent = true
+	ms := float64(time.Since(t.start).Microseconds()) / 1000
+	t.Header().Set(responseTimeHeader, strconv.FormatFloat(ms, 'f', 1, 64))
+}
+
+func (t *timingWriter) WriteHeader(status int) {
+	t.stamp()
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *timingWriter) Write(p []byte) (int, error) {
+	t.stamp()
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *timingWriter) Flush() {
+	t.stamp()
+	_ = http.NewResponseController(t.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (t *timingWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+// timingHandler adds the responseTimeHeader to responses, measured up to the
+// moment the header is sent, since it can't be changed afterwards.
+func timingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+type requestIDKey struct{}
+
+// requestIDHeader carries the request ID, both ways.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDFrom returns the ID requestIDHandler stored in ctx, if any.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether an incoming X-Request-ID is safe to reuse.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDHandler assigns each request an ID (reusing a valid incoming
+// X-Request-ID), stores it in the request context and echoes it in the
+// response header, where writeError picks it up.
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// logHandler logs one line per request, with its client IP, status,
+// duration and ID.
+func logHandler(trustedProxies proxySet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("%s %s %s %d %v id=%s", clientIP(r, trustedProxies), r.Method, r.URL.RequestURI(), rec.status,
+			time.Since(start).Round(time.Microsecond), requestIDFrom(r.Context()))
+	})
+}
+
+// corsHandler adds CORS headers for the allowed origins ("*" allows any) and
+// answers preflight OPTIONS requests. The API is read-only; POST is only
+// used by /api/portfolio for request bodies too long for a query string.
+func corsHandler(origins []string, next http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		if o == "*" {
+			allowAll = true
+		}
+		allowed[o] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !allowAll && !allowed[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowAll {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		h.Set("Access-Control-Expose-Headers", "ETag, X-Cache, "+requestIDHeader+", "+responseTimeHeader)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Accept, Content-Type, If-None-Match, If-Modified-Since, "+requestIDHeader)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryLimiterZeroWait(t *testing.T) {
+	l := newQueryLimiter(1, 0)
+	h := l.handler(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	// A free slot must always be taken, even when the wait timer fires at once.
+	for i := 0; i < 200; i++ {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/api/series", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d with a free slot", i, w.Code)
+		}
+	}
+
+	// With the slot taken, a zero wait gives up right away.
+	l.slots <- struct{}{}
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/api/series", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d with no free slot, want 503", w.Code)
+	}
+}
+
+func TestClientIPUnixPeer(t *testing.T) {
+	unixReq := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/series", nil)
+		r.RemoteAddr = "@"
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/ahdbweb.sock", Net: "unix"}))
+	}
+	none, _ := parseTrustedProxies(nil)
+	if got := clientIP(unixReq(), none); got != "@" {
+		t.Errorf("clientIP without the unix token = %q, want the peer", got)
+	}
+	unix, err := parseTrustedProxies([]string{"unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := clientIP(unixReq(), unix); got != "203.0.113.7" {
+		t.Errorf("clientIP with the unix token = %q, want the X-Forwarded-For client", got)
+	}
+}
+
+func TestPrettyHandler(t *testing.T) {
+	h := prettyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			io.WriteString(w, "a,b\n")
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+			io.WriteString(w, "1,2\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"compact"`)
+		io.WriteString(w, `{"a":1}`)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/json?pretty=true", nil))
+	if got, want := w.Body.String(), "{\n  \"a\": 1\n}"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag = %q on an indented body, want none", etag)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/csv?pretty=true", nil))
+	if !w.Flushed {
+		t.Error("non-JSON response was not flushed through")
+	}
+	if got, want := w.Body.String(), "a,b\n1,2\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxOHLCDays caps the range of /api/series/ohlc, which loads every scan of
+// it.
+const maxOHLCDays = 1000
+
+type ohlcCandle struct {
+	Day    string  `json:"day"` // UTC, 2006-01-02
+	TS     int64   `json:"ts"`  // start of the day
+	Scans  int     `json:"scans"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume int64   `json:"volume"` // summed quantity of the day's scans
+}
+
+type ohlcResponse struct {
+	Item    item         `json:"item"`
+	Realm   string       `json:"realm"`
+	Faction string       `json:"faction"`
+	Unit    string       `json:"unit"`
+	From    int64        `json:"from"`
+	To      int64        `json:"to"`
+	TrimPct int          `json:"trimPct"`
+	Candles []ohlcCandle `json:"candles"`
+}
+
+// ohlcCandles groups the TS sorted points by UTC day: open and close are the
+// medians of the day's first and last scans, high and low the extreme scan
+// medians.
+func ohlcCandles(points []seriesPoint) []ohlcCandle {
+	res := []ohlcCandle{}
+	for _, pt := range points {
+		day := pt.TS - pt.TS%86400
+		if len(res) == 0 || res[len(res)-1].TS != day {
+			res = append(res, ohlcCandle{
+				Day:  time.Unix(day, 0).UTC().Format("2006-01-02"),
+				TS:   day,
+				Open: pt.Median,
+				High: pt.Median,
+				Low:  pt.Median,
+			})
+		}
+		c := &res[len(res)-1]
+		c.Scans++
+		c.High = math.Max(c.High, pt.Median)
+		c.Low = math.Min(c.Low, pt.Median)
+		c.Close = pt.Median
+		c.Volume += pt.Quantity
+	}
+	return res
+}
+
+// handleOHLC returns daily open/high/low/close candles of an item's per scan
+// medians, for candlestick charts. It takes the /api/series params.
+func (s *server) handleOHLC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+	if p.Faction == factionBoth {
+		writeError(w, http.StatusBadRequest, "faction=both is not supported by ohlc")
+		return
+	}
+	if p.aggregated() {
+		writeError(w, http.StatusBadRequest, "realm=* and faction=* are not supported by ohlc")
+		return
+	}
+	// lastScans ignores the range, its cap already bounds the work.
+	if p.LastScans == 0 && p.To-p.From > maxOHLCDays*86400 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("range too large (max %d days)", maxOHLCDays))
+		return
+	}
+	// Candles need every scan of the range.
+	p.MaxPoints = math.MaxInt
+
+	var it item
+	err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series, err := s.loadSeries(ctx, p, []string{itemID})
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONWithETag(w, r, ohlcResponse{
+		Item:    it,
+		Realm:   p.Realm,
+		Faction: p.Faction,
+		Unit:    p.Unit,
+		From:    p.From,
+		To:      p.To,
+		TrimPct: p.TrimPct,
+		Candles: ohlcCandles(series[seriesKey{it.ID, p.Faction}]),
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits of a /api/portfolio request body.
+const (
+	maxPortfolioBody  = 64 << 10
+	maxPortfolioItems = 100
+)
+
+type portfolioRequest struct {
+	Realm   string `json:"realm"`
+	Faction string `json:"faction"`
+	// From and To are epoch seconds; To defaults to now and From to
+	// -default-days before To. They are pointers so that an explicit 0,
+	// the epoch, isn't taken for a missing value; parsePortfolioRequest
+	// sets both.
+	From  *int64 `json:"from"`
+	To    *int64 `json:"to"`
+	Items []struct {
+		ItemID string `json:"itemId"`
+		Unit   string `json:"unit"`
+	} `json:"items"`
+}
+
+type portfolioEntry struct {
+	Item         item     `json:"item"`
+	Unit         string   `json:"unit"`
+	Points       int      `json:"points"`
+	LatestTS     int64    `json:"latestTs,omitempty"`
+	LatestMedian float64  `json:"latestMedian"`
+	AbsChange    float64  `json:"absChange"`
+	PctChange    *float64 `json:"pctChange,omitempty"`
+}
+
+type portfolioResponse struct {
+	Realm   string           `json:"realm"`
+	Faction string           `json:"faction"`
+	From    int64            `json:"from"`
+	To      int64            `json:"to"`
+	Items   []portfolioEntry `json:"items"`
+}
+
+// parsePortfolioRequest decodes and validates the POST body of
+// /api/portfolio, filling in the default range.
+func (s *server) parsePortfolioRequest(r *http.Request, w http.ResponseWriter) (portfolioRequest, error) {
+	var req portfolioRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPortfolioBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, &statusError{http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body too large (max %d bytes)", maxPortfolioBody)}
+		}
+		return req, fmt.Errorf("invalid JSON body: %v", err)
+	}
+
+	fe := fieldErrors{}
+	if len(req.Items) == 0 {
+		fe.add("items", errors.New("missing items"))
+	} else if len(req.Items) > maxPortfolioItems {
+		fe.add("items", fmt.Errorf("too many items (max %d)", maxPortfolioItems))
+	}
+	for i := range req.Items {
+		it := &req.Items[i]
+		it.ItemID = strings.TrimSpace(it.ItemID)
+		if err := validateItemID(it.ItemID); err != nil {
+			fe.add(fmt.Sprintf("items[%d].itemId", i), err)
+		}
+		if it.Unit == "" {
+			it.Unit = "per_item"
+		}
+		if _, err := unitPriceExpr(it.Unit, "round"); err != nil {
+			fe.add(fmt.Sprintf("items[%d].unit", i), err)
+		}
+	}
+	if strings.EqualFold(req.Faction, factionBoth) {
+		fe.add("faction", errors.New("faction=both is not supported by portfolio"))
+	}
+	if req.To == nil {
+		now := time.Now().Unix()
+		req.To = &now
+	}
+	if req.From == nil {
+		var from int64
+		if s.defaultDays > 0 {
+			from = *req.To - s.defaultDays*86400
+		}
+		req.From = &from
+	}
+	if *req.From < 0 || *req.From > *req.To {
+		fe.add("from", errors.New("from must be between 0 and to"))
+	}
+	return req, fe.err()
+}
+
+// handlePortfolio summarizes a watchlist of items on one realm/faction: each
+// item's latest median and its change over the range. The list is POSTed as
+// JSON since it can be long. Unknown items are left out.
+func (s *server) handlePortfolio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, err := s.parsePortfolioRequest(r, w)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	if req.Realm == "" || req.Faction == "" {
+		rf, err := s.defaultRealmFaction(ctx)
+		if errors.Is(err, errNoScanData) {
+			writeErrorCode(w, http.StatusServiceUnavailable, codeNoScanData, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if req.Realm == "" {
+			req.Realm = rf.Realm
+		}
+		if req.Faction == "" {
+			req.Faction = rf.Faction
+		}
+	}
+	req.Realm, req.Faction, err = s.canonicalRealmFaction(ctx, req.Realm, req.Faction)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// One series query per unit, covering all of its items.
+	var ids []string
+	byUnit := make(map[string][]string)
+	var units []string
+	for _, it := range req.Items {
+		if byUnit[it.Unit] == nil {
+			units = append(units, it.Unit)
+		}
+		byUnit[it.Unit] = append(byUnit[it.Unit], it.ItemID)
+		ids = append(ids, it.ItemID)
+	}
+	items, err := s.loadItems(ctx, ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	series := make(map[string]map[seriesKey][]seriesPoint, len(units))
+	for _, unit := range units {
+		priceExpr, _ := unitPriceExpr(unit, "round")
+		p := seriesParams{
+			Unit:        unit,
+			RoundMode:   "round",
+			PriceExpr:   priceExpr,
+			PriceFilter: priceFilter(unit, priceExpr, false),
+			Realm:       req.Realm,
+			Faction:     req.Faction,
+			From:        *req.From,
+			To:          *req.To,
+			MaxPoints:   math.MaxInt,
+			Sample:      "recent",
+			MAWindow:    1,
+			PriceLimits: noPriceLimits,
+		}
+		if series[unit], err = s.loadSeries(ctx, p, byUnit[unit]); err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	res := portfolioResponse{Realm: req.Realm, Faction: req.Faction, From: *req.From, To: *req.To, Items: []portfolioEntry{}}
+	for _, reqItem := range req.Items {
+		it, ok := items[reqItem.ItemID]
+		if !ok {
+			continue
+		}
+		points := series[reqItem.Unit][seriesKey{it.ID, req.Faction}]
+		e := portfolioEntry{Item: it, Unit: reqItem.Unit, Points: len(points)}
+		if sum := summarize(points); sum != nil {
+			e.LatestTS = points[len(points)-1].TS
+			e.LatestMedian = sum.LastMedian
+			e.AbsChange = sum.AbsChange
+			e.PctChange = sum.PctChange
+		}
+		res.Items = append(res.Items, e)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
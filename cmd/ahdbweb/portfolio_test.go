@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPortfolioRequestFrom(t *testing.T) {
+	s := &server{defaultDays: 7}
+	tests := []struct {
+		body     string
+		wantFrom int64
+	}{
+		{`{"to": 1000000, "items": [{"itemId": "i1"}]}`, 1000000 - 7*86400},
+		{`{"from": 0, "to": 1000000, "items": [{"itemId": "i1"}]}`, 0},
+		{`{"from": 500, "to": 1000000, "items": [{"itemId": "i1"}]}`, 500},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/portfolio", strings.NewReader(tt.body))
+		req, err := s.parsePortfolioRequest(r, httptest.NewRecorder())
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if *req.From != tt.wantFrom {
+			t.Errorf("%s: from = %d, want %d", tt.body, *req.From, tt.wantFrom)
+		}
+	}
+}
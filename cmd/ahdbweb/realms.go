@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// knownRealmsTTL is how long the realms and factions seen in scanmeta are
+// reused. A name missing from them reloads the set sooner, at most every
+// knownRealmsRetry, so newly imported realms show up without hammering the DB
+// on bad input. A reload is given knownRealmsLoadTimeout, whatever the
+// deadline of the requests waiting on it.
+const (
+	knownRealmsTTL         = 5 * time.Minute
+	knownRealmsRetry       = 10 * time.Second
+	knownRealmsLoadTimeout = 10 * time.Second
+)
+
+// knownRealms maps the lowercased realm and faction names of scanmeta to
+// their stored spelling, so params match whatever the casing.
+type knownRealms struct {
+	mu       sync.Mutex
+	loaded   time.Time
+	realms   map[string]string
+	factions map[string]string
+	// reload is the load in flight, shared by the lookups needing it.
+	reload *realmsLoad
+}
+
+// realmsLoad is one reload of knownRealms; err is set before done is closed.
+type realmsLoad struct {
+	done chan struct{}
+	err  error
+}
+
+func (k *knownRealms) load(ctx context.Context, s *server) (realms, factions map[string]string, _ error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT realm, faction FROM scanmeta`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	realms, factions = make(map[string]string), make(map[string]string)
+	for rows.Next() {
+		var rf realmFaction
+		if err := rows.Scan(&rf.Realm, &rf.Faction); err != nil {
+			return nil, nil, err
+		}
+		realms[strings.ToLower(rf.Realm)] = rf.Realm
+		factions[strings.ToLower(rf.Faction)] = rf.Faction
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return realms, factions, nil
+}
+
+// refresh reloads the set, joining the reload in flight if there is one. The
+// query runs outside the lock on its own timeout, so a slow DB doesn't block
+// lookups of the current set and a cancelled request doesn't fail the others
+// waiting on the same reload.
+func (k *knownRealms) refresh(ctx context.Context, s *server) error {
+	k.mu.Lock()
+	l := k.reload
+	if l == nil {
+		l = &realmsLoad{done: make(chan struct{})}
+		k.reload = l
+		go func() {
+			loadCtx, cancel := context.WithTimeout(context.Background(), knownRealmsLoadTimeout)
+			defer cancel()
+			realms, factions, err := k.load(loadCtx, s)
+			k.mu.Lock()
+			if err == nil {
+				k.realms, k.factions, k.loaded = realms, factions, time.Now()
+			}
+			k.reload = nil
+			k.mu.Unlock()
+			l.err = err
+			close(l.done)
+		}()
+	}
+	k.mu.Unlock()
+	select {
+	case <-l.done:
+		return l.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// find looks name up in the map picked by get, also returning the age of the
+// set and whether it has been loaded at all.
+func (k *knownRealms) find(name string, get func(*knownRealms) map[string]string) (v string, ok bool, age time.Duration, loaded bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.realms == nil {
+		return "", false, 0, false
+	}
+	v, ok = get(k)[strings.ToLower(name)]
+	return v, ok, time.Since(k.loaded), true
+}
+
+// lookup returns the stored spelling of name in the realms or factions map
+// picked by get, reloading the set when it is stale or misses name.
+func (k *knownRealms) lookup(ctx context.Context, s *server, name string, get func(*knownRealms) map[string]string) (string, bool, error) {
+	v, ok, age, loaded := k.find(name, get)
+	if !loaded || age > knownRealmsTTL {
+		if err := k.refresh(ctx, s); err != nil {
+			return "", false, err
+		}
+		v, ok, age, _ = k.find(name, get)
+	}
+	if !ok && age > knownRealmsRetry {
+		if err := k.refresh(ctx, s); err != nil {
+			return "", false, err
+		}
+		v, ok, _, _ = k.find(name, get)
+	}
+	if !ok {
+		k.mu.Lock()
+		empty := len(k.realms) == 0
+		k.mu.Unlock()
+		if empty {
+			return "", false, errNoScanData
+		}
+	}
+	return v, ok, nil
+}
+
+// canonicalRealmFaction matches realm and faction case-insensitively against
+// the names in scanmeta and returns their stored spelling. Unknown names are
+// a 400, as they can only ever select nothing; the wildcard and both pass
+// through.
+func (s *server) canonicalRealmFaction(ctx context.Context, realm, faction string) (string, string, error) {
+	realms := func(k *knownRealms) map[string]string { return k.realms }
+	factions := func(k *knownRealms) map[string]string { return k.factions }
+	fe := fieldErrors{}
+	if realm != wildcard {
+		v, ok, err := s.canonicalName(ctx, realm, realms)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			fe.add("realm", fmt.Errorf("unknown realm %q", realm))
+		}
+		realm = v
+	}
+	switch {
+	case faction == wildcard:
+	case strings.EqualFold(faction, factionBoth):
+		faction = factionBoth
+	default:
+		v, ok, err := s.canonicalName(ctx, faction, factions)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			fe.add("faction", fmt.Errorf("unknown faction %q", faction))
+		}
+		faction = v
+	}
+	if err := fe.err(); err != nil {
+		return "", "", err
+	}
+	return realm, faction, nil
+}
+
+func (s *server) canonicalName(ctx context.Context, name string, get func(*knownRealms) map[string]string) (string, bool, error) {
+	v, ok, err := s.known.lookup(ctx, s, name, get)
+	if errors.Is(err, errNoScanData) {
+		return "", false, &statusError{http.StatusServiceUnavailable, err}
+	}
+	if err != nil {
+		return "", false, &statusError{http.StatusInternalServerError, err}
+	}
+	return v, ok, nil
+}
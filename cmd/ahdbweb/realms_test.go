@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func newRealmsServer(t *testing.T) *server {
+	db := newFakeDB(t, fakeRowsFor("FROM scanmeta", fakeResult{
+		columns: []string{"realm", "faction"},
+		rows: [][]driver.Value{
+			{"Stormrage", "Horde"},
+			{"Stormrage", "Alliance"},
+			{"Mankrik", "Horde"},
+		},
+	}))
+	return &server{db: db, readDB: db}
+}
+
+func TestRealmFactionParamsCanonical(t *testing.T) {
+	s := newRealmsServer(t)
+	tests := []struct {
+		realm, faction         string
+		wantRealm, wantFaction string
+	}{
+		{"Stormrage", "Horde", "Stormrage", "Horde"},
+		{"stormrage", "horde", "Stormrage", "Horde"},
+		{"  MANKRIK ", "hOrDe", "Mankrik", "Horde"},
+		{"stormRAGE", "ALLIANCE", "Stormrage", "Alliance"},
+		{"Stormrage", "Both", "Stormrage", factionBoth},
+		{"stormrage", "both", "Stormrage", factionBoth},
+		{"*", "*", "*", "*"},
+		{"*", "horde", "*", "Horde"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.realm+"/"+tt.faction, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"realm": {tt.realm}, "faction": {tt.faction}}.Encode(), nil)
+			realm, faction, err := s.realmFactionParams(context.Background(), r)
+			if err != nil {
+				t.Fatalf("realmFactionParams: %v", err)
+			}
+			if realm != tt.wantRealm || faction != tt.wantFaction {
+				t.Errorf("got %q/%q, want %q/%q", realm, faction, tt.wantRealm, tt.wantFaction)
+			}
+		})
+	}
+}
+
+func TestRealmFactionParamsUnknown(t *testing.T) {
+	s := newRealmsServer(t)
+	tests := []struct {
+		name, realm, faction string
+		wantCode             string
+		wantFields           []string
+	}{
+		{name: "unknown realm", realm: "Nowhere", faction: "Horde", wantCode: codeInvalidParam},
+		{name: "unknown faction", realm: "Stormrage", faction: "Pirates", wantCode: codeInvalidParam},
+		{name: "both unknown", realm: "Nowhere", faction: "Pirates", wantCode: codeValidationFailed, wantFields: []string{"realm", "faction"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"realm": {tt.realm}, "faction": {tt.faction}}.Encode(), nil)
+			_, _, err := s.realmFactionParams(context.Background(), r)
+			if err == nil {
+				t.Fatal("realmFactionParams: want an error")
+			}
+			w := httptest.NewRecorder()
+			writeErrorFor(w, err, http.StatusBadRequest) // as the handlers do
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			var res errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", res.Code, tt.wantCode)
+			}
+			for _, key := range tt.wantFields {
+				if res.Fields[key] == "" {
+					t.Errorf("fields[%q] missing in %v", key, res.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestSingleRealmFactionParams(t *testing.T) {
+	s := newRealmsServer(t)
+	for _, q := range []string{"realm=*&faction=Horde", "realm=Stormrage&faction=*", "realm=Stormrage&faction=Both"} {
+		t.Run(q, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+q, nil)
+			_, _, err := s.singleRealmFactionParams(context.Background(), r, "scans")
+			w := httptest.NewRecorder()
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+	r := httptest.NewRequest(http.MethodGet, "/?realm=stormrage&faction=horde", nil)
+	realm, faction, err := s.singleRealmFactionParams(context.Background(), r, "scans")
+	if err != nil || realm != "Stormrage" || faction != "Horde" {
+		t.Errorf("got %q/%q, %v, want Stormrage/Horde", realm, faction, err)
+	}
+}
+
+func TestKnownRealmsReloadOutlivesRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	db := newFakeDB(t, func(string, []driver.NamedValue) fakeResult {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return fakeResult{columns: []string{"realm", "faction"}, rows: [][]driver.Value{{"Stormrage", "Horde"}}}
+	})
+	s := &server{db: db, readDB: db}
+	realms := func(k *knownRealms) map[string]string { return k.realms }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, _, err := s.known.lookup(ctx, s, "stormrage", realms)
+		errc <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled lookup: err = %v, want context.Canceled", err)
+	}
+
+	// The reload is still in flight: a second lookup joins it rather than
+	// querying again, and gets its result.
+	go func() { close(release) }()
+	v, ok, err := s.known.lookup(context.Background(), s, "stormrage", realms)
+	if err != nil || !ok || v != "Stormrage" {
+		t.Errorf("lookup = %q, %v, %v, want Stormrage", v, ok, err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d scanmeta queries, want 1", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResampleBuckets caps how many buckets a resample request may span.
+const maxResampleBuckets = 5000
+
+type resampleBucket struct {
+	Start    int64   `json:"start"`
+	StartISO string  `json:"startIso"`
+	Scans    int     `json:"scans"`
+	N        int     `json:"n"`
+	Quantity int64   `json:"quantity"`
+	Min      float64 `json:"min"`
+	Q1       float64 `json:"q1"`
+	Median   float64 `json:"median"`
+	Q3       float64 `json:"q3"`
+	Max      float64 `json:"max"`
+	Mean     float64 `json:"mean"`
+}
+
+type resampleResponse struct {
+	Item     item             `json:"item"`
+	Realm    string           `json:"realm"`
+	Faction  string           `json:"faction"`
+	Unit     string           `json:"unit"`
+	From     int64            `json:"from"`
+	To       int64            `json:"to"`
+	TrimPct  int              `json:"trimPct"`
+	Weighted bool             `json:"weighted"`
+	MinN     int              `json:"minN"`
+	Interval int64            `json:"interval"`
+	Buckets  []resampleBucket `json:"buckets"`
+}
+
+// parseIntervalParam parses the bucket width, as seconds ("3600"), days
+// ("1d") or a Go duration ("6h"). It defaults to an hour.
+func parseIntervalParam(r *http.Request) (int64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("interval"))
+	if raw == "" {
+		return 3600, nil
+	}
+	var secs int64
+	if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		secs = v
+	} else if days, ok := strings.CutSuffix(raw, "d"); ok {
+		v, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid interval")
+		}
+		secs = v * 86400
+	} else {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, errors.New("invalid interval")
+		}
+		secs = int64(d / time.Second)
+	}
+	if secs < 60 {
+		return 0, errors.New("interval must be at least 60 seconds")
+	}
+	return secs, nil
+}
+
+// bucketAccumulator pools the prices of a bucket's scans. Unlike those of a
+// single scan, they come unsorted.
+type bucketAccumulator struct {
+	acc      scanAccumulator
+	scans    int
+	lastScan int64
+}
+
+func (b *bucketAccumulator) Len() int           { return len(b.acc.prices) }
+func (b *bucketAccumulator) Less(i, j int) bool { return b.acc.prices[i] < b.acc.prices[j] }
+func (b *bucketAccumulator) Swap(i, j int) {
+	b.acc.prices[i], b.acc.prices[j] = b.acc.prices[j], b.acc.prices[i]
+	b.acc.counts[i], b.acc.counts[j] = b.acc.counts[j], b.acc.counts[i]
+}
+
+// handleSeriesResample pools the prices of all the scans falling in each
+// fixed interval bucket and computes statistics over the pooled set, as the
+// series does for each scan: trimPct, weighted and minN apply to the buckets.
+func (s *server) handleSeriesResample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval, err := parseIntervalParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+	if p.Faction == factionBoth {
+		writeError(w, http.StatusBadRequest, "faction=both is not supported by resample")
+		return
+	}
+	if p.aggregated() {
+		writeError(w, http.StatusBadRequest, "realm=* and faction=* are not supported by resample")
+		return
+	}
+	if p.MAWindow > 1 {
+		writeError(w, http.StatusBadRequest, "maWindow is not supported by resample")
+		return
+	}
+	if p.Fields != nil {
+		writeError(w, http.StatusBadRequest, "fields is not supported by resample")
+		return
+	}
+	// lastScans ignores the range, its cap already bounds the work.
+	if p.LastScans == 0 && (p.To-p.From)/interval > maxResampleBuckets {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many buckets (max %d), use a larger interval or narrower range", maxResampleBuckets))
+		return
+	}
+
+	var it item
+	err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	buckets := make(map[int64]*bucketAccumulator)
+	err = s.querySeriesRows(ctx, p, []string{itemID}, func(row seriesRow) {
+		start := row.ts - row.ts%interval
+		b := buckets[start]
+		if b == nil {
+			b = &bucketAccumulator{lastScan: -1}
+			buckets[start] = b
+		}
+		if b.lastScan != row.scanID {
+			b.scans++
+			b.lastScan = row.scanID
+		}
+		b.acc.prices = append(b.acc.prices, row.price)
+		b.acc.counts = append(b.acc.counts, row.count)
+		b.acc.quantity += row.count
+	})
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]resampleBucket, 0, len(buckets))
+	for start, b := range buckets {
+		sort.Sort(b)
+		limited := b.acc.limited(p.PriceLimits)
+		pt := limited.point(p.TrimPct, p.Weighted)
+		if pt.N == 0 || pt.N < p.MinN {
+			continue
+		}
+		res = append(res, resampleBucket{
+			Start:    start,
+			StartISO: formatTS(start),
+			Scans:    b.scans,
+			N:        pt.N,
+			Quantity: pt.Quantity,
+			Min:      pt.Min,
+			Q1:       pt.Q1,
+			Median:   pt.Median,
+			Q3:       pt.Q3,
+			Max:      pt.Max,
+			Mean:     pt.Mean,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Start < res[j].Start })
+
+	writeJSONWithETag(w, r, resampleResponse{
+		Item:     it,
+		Realm:    p.Realm,
+		Faction:  p.Faction,
+		Unit:     p.Unit,
+		From:     p.From,
+		To:       p.To,
+		TrimPct:  p.TrimPct,
+		Weighted: p.Weighted,
+		MinN:     p.MinN,
+		Interval: interval,
+		Buckets:  res,
+	})
+}
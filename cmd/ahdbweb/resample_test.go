@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResampleWeightedMinN(t *testing.T) {
+	// Two scans in the first hour bucket, pooled into 10 x1, 20 x1 and
+	// 30 x2, and a lone auction in the second one.
+	db := newFakeDB(t, func(query string, _ []driver.NamedValue) fakeResult {
+		switch {
+		case strings.Contains(query, "FROM scanmeta"):
+			return fakeResult{columns: []string{"realm", "faction"}, rows: [][]driver.Value{{"Stormrage", "Horde"}}}
+		case strings.Contains(query, "FROM items"):
+			return fakeResult{columns: []string{"id", "name", "shortid"}, rows: [][]driver.Value{{"i1", "Item", int64(1)}}}
+		case strings.Contains(query, "FROM auctions a"):
+			return fakeResult{
+				columns: []string{"itemId", "faction", "scanId", "ts", "price", "itemCount", "seller"},
+				rows: [][]driver.Value{
+					{"i1", "Horde", int64(1), int64(3600), float64(10), int64(1), ""},
+					{"i1", "Horde", int64(1), int64(3600), float64(30), int64(2), ""},
+					{"i1", "Horde", int64(2), int64(4000), float64(20), int64(1), ""},
+					{"i1", "Horde", int64(3), int64(7200), float64(40), int64(1), ""},
+				},
+			}
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	})
+	s := &server{db: db, readDB: db, seriesTimeout: time.Minute}
+
+	w := httptest.NewRecorder()
+	s.handleSeriesResample(w, httptest.NewRequest(http.MethodGet,
+		"/api/series/resample?itemId=i1&realm=Stormrage&faction=Horde&from=0&to=10000&weighted=true&minN=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var res resampleResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Buckets) != 1 {
+		t.Fatalf("buckets = %+v, want only the first one (minN=2)", res.Buckets)
+	}
+	b := res.Buckets[0]
+	if b.Start != 3600 || b.Scans != 2 || b.N != 3 {
+		t.Errorf("bucket = %+v, want start 3600, 2 scans and 3 prices", b)
+	}
+	if b.Median != 25 || b.Mean != 22.5 {
+		t.Errorf("median, mean = %v, %v, want the weighted 25, 22.5", b.Median, b.Mean)
+	}
+}
+
+func TestResampleRejectsSeriesOnlyParams(t *testing.T) {
+	s := newRealmsServer(t)
+	s.seriesTimeout = time.Minute
+	for _, q := range []string{"maWindow=5", "fields=ts,median"} {
+		t.Run(q, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleSeriesResample(w, httptest.NewRequest(http.MethodGet,
+				"/api/series/resample?itemId=i1&realm=Stormrage&faction=Horde&"+q, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type searchResponse struct {
+	Items  []item         `json:"items"`
+	Realms []realmFaction `json:"realms"`
+}
+
+// handleSearch serves the unified search box: the items and the realm/faction
+// pairs whose name contains q, each capped at limit (default 10, max 50).
+// Items are ranked like the default /api/items search.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, err := parseIntParam(r, "limit", 10)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	limit = min(limit, 50)
+
+	res := searchResponse{Items: []item{}, Realms: []realmFaction{}}
+	if len(q) < s.minSearchLen {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	if len(q) > 64 {
+		q = q[:64]
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	done := s.metrics.timeQuery("search")
+	rows, err := s.readDB.QueryContext(ctx, `
+SELECT id, name, shortid FROM items
+WHERE name LIKE ?
+ORDER BY `+itemSortOrders["relevance"]+`
+LIMIT ?`, "%"+q+"%", q, q+"%", limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.ID, &it.Name, &it.ShortID); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res.Items = append(res.Items, it)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	realmRows, err := s.db.QueryContext(ctx, `
+SELECT DISTINCT realm, faction FROM scanmeta
+WHERE realm LIKE ?
+ORDER BY realm, faction
+LIMIT ?`, "%"+q+"%", limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer realmRows.Close()
+	for realmRows.Next() {
+		var rf realmFaction
+		if err := realmRows.Scan(&rf.Realm, &rf.Faction); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res.Realms = append(res.Realms, rf)
+	}
+	if err := realmRows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type seasonBucket struct {
+	Bucket int `json:"bucket"` // hour 0-23 or weekday 0-6 (Sunday is 0), UTC
+	Scans  int `json:"scans"`
+	// AvgMedian is the mean of the bucket's scan medians, null without scans.
+	AvgMedian *float64 `json:"avgMedian"`
+}
+
+type seasonalityResponse struct {
+	Item      item           `json:"item"`
+	Realm     string         `json:"realm"`
+	Faction   string         `json:"faction"`
+	Unit      string         `json:"unit"`
+	From      int64          `json:"from"`
+	To        int64          `json:"to"`
+	TrimPct   int            `json:"trimPct"`
+	Scans     int            `json:"scans"`
+	HourOfDay []seasonBucket `json:"hourOfDay"`
+	DayOfWeek []seasonBucket `json:"dayOfWeek"`
+}
+
+// seasonBuckets averages the point medians into n buckets keyed by bucket.
+func seasonBuckets(points []seriesPoint, n int, bucket func(time.Time) int) []seasonBucket {
+	sums := make([]float64, n)
+	res := make([]seasonBucket, n)
+	for i := range res {
+		res[i].Bucket = i
+	}
+	for _, pt := range points {
+		b := bucket(time.Unix(pt.TS, 0).UTC())
+		res[b].Scans++
+		sums[b] += pt.Median
+	}
+	for i := range res {
+		if res[i].Scans > 0 {
+			avg := sums[i] / float64(res[i].Scans)
+			res[i].AvgMedian = &avg
+		}
+	}
+	return res
+}
+
+// handleSeasonality averages an item's per scan medians by UTC hour of day
+// and day of week of the scans, to see when prices dip. It takes the
+// /api/series params.
+func (s *server) handleSeasonality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+	if p.Faction == factionBoth {
+		writeError(w, http.StatusBadRequest, "faction=both is not supported by seasonality")
+		return
+	}
+	if p.aggregated() {
+		writeError(w, http.StatusBadRequest, "realm=* and faction=* are not supported by seasonality")
+		return
+	}
+	// lastScans ignores the range, its cap already bounds the work.
+	if p.LastScans == 0 && p.To-p.From > maxOHLCDays*86400 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("range too large (max %d days)", maxOHLCDays))
+		return
+	}
+	// Downsampled points would skew the buckets.
+	p.MaxPoints = math.MaxInt
+
+	var it item
+	err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series, err := s.loadSeries(ctx, p, []string{itemID})
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+	points := series[seriesKey{it.ID, p.Faction}]
+
+	writeJSONWithETag(w, r, seasonalityResponse{
+		Item:      it,
+		Realm:     p.Realm,
+		Faction:   p.Faction,
+		Unit:      p.Unit,
+		From:      p.From,
+		To:        p.To,
+		TrimPct:   p.TrimPct,
+		Scans:     len(points),
+		HourOfDay: seasonBuckets(points, 24, func(t time.Time) int { return t.Hour() }),
+		DayOfWeek: seasonBuckets(points, 7, func(t time.Time) int { return int(t.Weekday()) }),
+	})
+}
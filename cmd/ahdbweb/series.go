@@ -0,0 +1,1411 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type seriesPoint struct {
+	ScanID   int64   `json:"scanId"`
+	TS       int64   `json:"ts"`
+	TSISO    string  `json:"tsIso"`
+	N        int     `json:"n"`
+	Quantity int64   `json:"quantity"` // sum of itemCount, before trimming
+	Min      float64 `json:"min"`
+	Q1       float64 `json:"q1"`
+	Q3       float64 `json:"q3"`
+	Max      float64 `json:"max"`
+	P10      float64 `json:"p10"`
+	P90      float64 `json:"p90"`
+	P95      float64 `json:"p95"`
+	P99      float64 `json:"p99"`
+	Mean     float64 `json:"mean"`
+	Median   float64 `json:"median"`
+	Stddev   float64 `json:"stddev"`
+	MAD      float64 `json:"mad"` // median absolute deviation from the median
+
+	MarketValue float64 `json:"marketValue"`
+
+	// IsLowest and IsHighest mark the points with the lowest and highest
+	// median of the series, the earliest one on ties.
+	IsLowest  bool `json:"isLowest,omitempty"`
+	IsHighest bool `json:"isHighest,omitempty"`
+
+	// Set with humanize=true, the above prices formatted as "15g 87s 34c".
+	MinFmt         string `json:"minFmt,omitempty"`
+	Q1Fmt          string `json:"q1Fmt,omitempty"`
+	MedianFmt      string `json:"medianFmt,omitempty"`
+	Q3Fmt          string `json:"q3Fmt,omitempty"`
+	MaxFmt         string `json:"maxFmt,omitempty"`
+	MeanFmt        string `json:"meanFmt,omitempty"`
+	MarketValueFmt string `json:"marketValueFmt,omitempty"`
+
+	// Gold repeats the prices in gold, with unitScale=gold.
+	Gold *goldPrices `json:"gold,omitempty"`
+}
+
+func (pt *seriesPoint) humanize() {
+	pt.MinFmt = formatCopperFloat(pt.Min)
+	pt.Q1Fmt = formatCopperFloat(pt.Q1)
+	pt.MedianFmt = formatCopperFloat(pt.Median)
+	pt.Q3Fmt = formatCopperFloat(pt.Q3)
+	pt.MaxFmt = formatCopperFloat(pt.Max)
+	pt.MeanFmt = formatCopperFloat(pt.Mean)
+	pt.MarketValueFmt = formatCopperFloat(pt.MarketValue)
+}
+
+// copperPerGold converts copper prices to gold.
+const copperPerGold = 10000
+
+type goldPrices struct {
+	Min         float64 `json:"min"`
+	Q1          float64 `json:"q1"`
+	Median      float64 `json:"median"`
+	Q3          float64 `json:"q3"`
+	Max         float64 `json:"max"`
+	Mean        float64 `json:"mean"`
+	MarketValue float64 `json:"marketValue"`
+}
+
+func (pt *seriesPoint) addGold() {
+	pt.Gold = &goldPrices{
+		Min:         pt.Min / copperPerGold,
+		Q1:          pt.Q1 / copperPerGold,
+		Median:      pt.Median / copperPerGold,
+		Q3:          pt.Q3 / copperPerGold,
+		Max:         pt.Max / copperPerGold,
+		Mean:        pt.Mean / copperPerGold,
+		MarketValue: pt.MarketValue / copperPerGold,
+	}
+}
+
+// parseUnitScaleParam parses unitScale: copper (the default) or gold, which
+// adds the prices in gold next to the copper ones for axis labels.
+func parseUnitScaleParam(r *http.Request) (string, error) {
+	scale := strings.TrimSpace(r.URL.Query().Get("unitScale"))
+	switch scale {
+	case "":
+		return "copper", nil
+	case "copper", "gold":
+		return scale, nil
+	default:
+		return "", errors.New("invalid unitScale (expected copper or gold)")
+	}
+}
+
+type seriesResponse struct {
+	Item     item          `json:"item"`
+	Realm    string        `json:"realm"`
+	Faction  string        `json:"faction"`
+	Unit     string        `json:"unit"`
+	From     int64         `json:"from"`
+	To       int64         `json:"to"`
+	TrimPct  int           `json:"trimPct"`
+	MAWindow int           `json:"maWindow"`
+	Weighted bool          `json:"weighted"`
+	MinN     int           `json:"minN"`
+	Sample   string        `json:"sample"`
+	Points   []seriesPoint `json:"points"`
+	// Factions holds the per-faction points when faction=both was requested.
+	Factions map[string][]seriesPoint `json:"factions,omitempty"`
+	// Aggregated is set when realm=* or faction=* pooled several realms or
+	// factions into aggregateBucketSecs buckets.
+	Aggregated bool `json:"aggregated,omitempty"`
+	// Summary compares the first and last points, omitted without points
+	// and with faction=both.
+	Summary *seriesSummary `json:"summary,omitempty"`
+	// Trend is the trend=linear regression of the points, omitted with
+	// fewer than 2 points and with faction=both.
+	Trend *seriesTrend `json:"trend,omitempty"`
+	// Truncated is set, with a 206 status, when the query ran out of time
+	// and only the scans read until then are returned.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// fields restricts the JSON points to these keys (see jsonValue).
+	fields map[string]bool
+}
+
+// seriesTrend is the least squares line median = Slope*ts + Intercept.
+type seriesTrend struct {
+	Slope     float64 `json:"slope"` // copper per second
+	Intercept float64 `json:"intercept"`
+	R2        float64 `json:"r2"`
+}
+
+// linearTrend fits a line through the (ts, median) of points, returning nil
+// when there aren't two distinct timestamps.
+func linearTrend(points []seriesPoint) *seriesTrend {
+	n := float64(len(points))
+	if n < 2 {
+		return nil
+	}
+	var meanX, meanY float64
+	for _, pt := range points {
+		meanX += float64(pt.TS)
+		meanY += pt.Median
+	}
+	meanX /= n
+	meanY /= n
+	// Centering keeps the epoch sized timestamps from eating the precision.
+	var sxx, sxy, syy float64
+	for _, pt := range points {
+		dx, dy := float64(pt.TS)-meanX, pt.Median-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return nil
+	}
+	t := &seriesTrend{Slope: sxy / sxx}
+	t.Intercept = meanY - t.Slope*meanX
+	t.R2 = 1
+	if syy > 0 {
+		t.R2 = sxy * sxy / (sxx * syy)
+	}
+	return t
+}
+
+// parseTrendParam parses the trend line to fit over the points: "" for none
+// or linear.
+func parseTrendParam(r *http.Request) (string, error) {
+	trend := strings.TrimSpace(r.URL.Query().Get("trend"))
+	switch trend {
+	case "", "linear":
+		return trend, nil
+	default:
+		return "", errors.New("invalid trend (expected linear)")
+	}
+}
+
+// seriesSummary is the median change over the returned points.
+type seriesSummary struct {
+	FirstMedian float64 `json:"firstMedian"`
+	LastMedian  float64 `json:"lastMedian"`
+	AbsChange   float64 `json:"absChange"`
+	// PctChange is omitted when the first median is 0.
+	PctChange *float64 `json:"pctChange,omitempty"`
+}
+
+// newestTS returns the TS of the most recent point of resp, 0 without any.
+func (resp seriesResponse) newestTS() int64 {
+	var ts int64
+	if n := len(resp.Points); n > 0 {
+		ts = resp.Points[n-1].TS
+	}
+	for _, points := range resp.Factions {
+		if n := len(points); n > 0 {
+			ts = max(ts, points[n-1].TS)
+		}
+	}
+	return ts
+}
+
+// summarize returns the summary of the TS sorted points, nil when empty.
+func summarize(points []seriesPoint) *seriesSummary {
+	if len(points) == 0 {
+		return nil
+	}
+	first, last := points[0].Median, points[len(points)-1].Median
+	sum := &seriesSummary{FirstMedian: first, LastMedian: last, AbsChange: last - first}
+	if first != 0 {
+		pct := (last - first) / first * 100
+		sum.PctChange = &pct
+	}
+	return sum
+}
+
+type scanAccumulator struct {
+	scanID   int64
+	ts       int64
+	quantity int64
+	prices   []float64
+	counts   []int64 // itemCount of each price, for weighted stats
+}
+
+func medianSorted(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// madSorted returns the median absolute deviation of sorted values from
+// their median. The deviations of the values below and above the median are
+// each already ordered, so they are merged rather than sorted.
+func madSorted(values []float64, median float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	split := sort.Search(n, func(i int) bool { return values[i] >= median })
+	devs := make([]float64, 0, n)
+	lo, hi := split-1, split
+	for lo >= 0 || hi < n {
+		if hi >= n || (lo >= 0 && median-values[lo] <= values[hi]-median) {
+			devs = append(devs, median-values[lo])
+			lo--
+		} else {
+			devs = append(devs, values[hi]-median)
+			hi++
+		}
+	}
+	if n%2 == 1 {
+		return devs[n/2]
+	}
+	return (devs[n/2-1] + devs[n/2]) / 2
+}
+
+// quartilesSorted returns the quartiles of sorted values, using the medians of
+// the lower and upper halves (excluding the median itself for odd counts).
+func quartilesSorted(values []float64) (q1, median, q3 float64) {
+	n := len(values)
+	median = medianSorted(values)
+	q1 = median
+	q3 = median
+	if n > 1 {
+		var lower []float64
+		var upper []float64
+		if n%2 == 0 {
+			lower = values[:n/2]
+			upper = values[n/2:]
+		} else {
+			lower = values[:n/2]
+			upper = values[n/2+1:]
+		}
+		if len(lower) > 0 {
+			q1 = medianSorted(lower)
+		}
+		if len(upper) > 0 {
+			q3 = medianSorted(upper)
+		}
+	}
+	return q1, median, q3
+}
+
+// percentileSorted returns the p-th percentile (0..100) of sorted values,
+// linearly interpolating between the two closest ranks.
+func percentileSorted(values []float64, p float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	if n == 1 || p <= 0 {
+		return values[0]
+	}
+	if p >= 100 {
+		return values[n-1]
+	}
+	rank := p / 100 * float64(n-1)
+	lo := int(math.Floor(rank))
+	hi := lo + 1
+	if hi >= n {
+		return values[lo]
+	}
+	frac := rank - float64(lo)
+	return values[lo] + frac*(values[hi]-values[lo])
+}
+
+func (a *scanAccumulator) reset(scanID, ts int64) {
+	a.scanID = scanID
+	a.ts = ts
+	a.quantity = 0
+	a.prices = a.prices[:0]
+	a.counts = a.counts[:0]
+}
+
+func (a *scanAccumulator) add(price float64, count int64) {
+	a.prices = append(a.prices, price)
+	a.counts = append(a.counts, count)
+	a.quantity += count
+}
+
+// trimCount returns how many values trimPct percent trims from each end of n
+// sorted values, rounded down and always leaving at least one.
+func trimCount(n, trimPct int) int {
+	if trimPct <= 0 || n == 0 {
+		return 0
+	}
+	trim := int(math.Floor(float64(n) * (float64(trimPct) / 100.0)))
+	maxTrim := (n - 1) / 2
+	if trim > maxTrim {
+		trim = maxTrim
+	}
+	return trim
+}
+
+func trimSorted(values []float64, trimPct int) []float64 {
+	trim := trimCount(len(values), trimPct)
+	return values[trim : len(values)-trim]
+}
+
+// priceLimits are the minPrice/maxPrice params, dropping prices outside
+// [Min, Max] before any percentile trimming.
+type priceLimits struct {
+	Min, Max int64
+}
+
+var noPriceLimits = priceLimits{Min: 0, Max: math.MaxInt64}
+
+// indexes returns the [i, j) range of the sorted values within l.
+func (l priceLimits) indexes(sorted []float64) (i, j int) {
+	i = sort.Search(len(sorted), func(k int) bool { return sorted[k] >= float64(l.Min) })
+	j = sort.Search(len(sorted), func(k int) bool { return sorted[k] > float64(l.Max) })
+	return i, max(i, j)
+}
+
+func (l priceLimits) apply(sorted []float64) []float64 {
+	i, j := l.indexes(sorted)
+	return sorted[i:j]
+}
+
+// parsePriceLimitsParams parses the optional minPrice and maxPrice params,
+// recording invalid ones in fe.
+func parsePriceLimitsParams(r *http.Request, fe fieldErrors) priceLimits {
+	l := noPriceLimits
+	minP, err := parseIntParam(r, "minPrice", l.Min)
+	if err != nil || minP < 0 {
+		fe.add("minPrice", errors.New("invalid minPrice"))
+	} else {
+		l.Min = minP
+	}
+	maxP, err := parseIntParam(r, "maxPrice", l.Max)
+	if err != nil || maxP < 0 {
+		fe.add("maxPrice", errors.New("invalid maxPrice"))
+	} else {
+		l.Max = maxP
+	}
+	if l.Min > l.Max {
+		fe.add("minPrice", errors.New("minPrice must be <= maxPrice"))
+	}
+	return l
+}
+
+// weightedMedianSorted returns the median of sorted values where each value
+// is repeated weights[i] times.
+func weightedMedianSorted(values []float64, weights []int64) float64 {
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return medianSorted(values)
+	}
+	var cum int64
+	for i, w := range weights {
+		cum += w
+		if 2*cum == total && i+1 < len(values) {
+			return (values[i] + values[i+1]) / 2
+		}
+		if 2*cum >= total {
+			return values[i]
+		}
+	}
+	return values[len(values)-1]
+}
+
+// limited returns a view of a with only the prices (and their counts) within
+// l. The quantity still covers all of them.
+func (a *scanAccumulator) limited(l priceLimits) scanAccumulator {
+	i, j := l.indexes(a.prices)
+	res := *a
+	res.prices = a.prices[i:j]
+	res.counts = a.counts[i:j]
+	return res
+}
+
+// point computes the statistics of the accumulated scan. When weighted is set
+// the mean and median weigh each listing's price by its itemCount.
+func (a *scanAccumulator) point(trimPct int, weighted bool) seriesPoint {
+	if len(a.prices) == 0 {
+		return seriesPoint{}
+	}
+	trim := trimCount(len(a.prices), trimPct)
+	prices := a.prices[trim : len(a.prices)-trim]
+	counts := a.counts[trim : len(a.counts)-trim]
+	n := len(prices)
+	if n == 0 {
+		return seriesPoint{}
+	}
+
+	var mean float64
+	var m2 float64
+	for i := range prices {
+		x := prices[i]
+		delta := x - mean
+		mean += delta / float64(i+1)
+		delta2 := x - mean
+		m2 += delta * delta2
+	}
+
+	minV := prices[0]
+	maxV := prices[n-1]
+	q1, median, q3 := quartilesSorted(prices)
+	mad := madSorted(prices, median)
+	var variance float64
+	if n > 0 {
+		variance = m2 / float64(n)
+	}
+	if weighted {
+		var sum, total float64
+		for i, p := range prices {
+			sum += p * float64(counts[i])
+			total += float64(counts[i])
+		}
+		if total > 0 {
+			mean = sum / total
+		}
+		median = weightedMedianSorted(prices, counts)
+	}
+	return seriesPoint{
+		ScanID:   a.scanID,
+		TS:       a.ts,
+		TSISO:    formatTS(a.ts),
+		N:        n,
+		Quantity: a.quantity,
+		Min:      minV,
+		Q1:       q1,
+		Q3:       q3,
+		Max:      maxV,
+		P10:      percentileSorted(prices, 10),
+		P90:      percentileSorted(prices, 90),
+		P95:      percentileSorted(prices, 95),
+		P99:      percentileSorted(prices, 99),
+		Mean:     mean,
+		Median:   median,
+		Stddev:   math.Sqrt(variance),
+		MAD:      mad,
+	}
+}
+
+func parseMaxPointsParam(r *http.Request) (int, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("maxPoints"))
+	if raw == "" {
+		return 400, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.New("invalid maxPoints")
+	}
+	if v < 10 {
+		return 10, nil
+	}
+	if v > 5000 {
+		return 5000, nil
+	}
+	return v, nil
+}
+
+// parseSampleParam parses how series longer than maxPoints are cut down:
+// "recent" (the default) keeps the most recent points, "uniform" keeps evenly
+// spaced points across the whole range, including the first and last.
+func parseSampleParam(r *http.Request) (string, error) {
+	sample := strings.TrimSpace(r.URL.Query().Get("sample"))
+	switch sample {
+	case "":
+		return "recent", nil
+	case "recent", "uniform":
+		return sample, nil
+	default:
+		return "", errors.New("invalid sample (expected recent or uniform)")
+	}
+}
+
+// samplePoints cuts the TS sorted points down to maxPoints as per sample.
+func samplePoints(points []seriesPoint, maxPoints int, sample string) []seriesPoint {
+	n := len(points)
+	if n <= maxPoints {
+		return points
+	}
+	if sample != "uniform" || maxPoints < 2 {
+		return points[n-maxPoints:]
+	}
+	res := make([]seriesPoint, maxPoints)
+	for i := range res {
+		res[i] = points[int(math.Round(float64(i)*float64(n-1)/float64(maxPoints-1)))]
+	}
+	return res
+}
+
+// parseDedupeParams parses dedupe=true and dedupeMax (default 3), for
+// sellers flooding the AH with copies of one listing. Within a scan (a
+// pooled bucket with realm=* or faction=*), auctions with the same seller,
+// per unit price and itemCount are identical listings, and only the first
+// dedupeMax of them are counted; the others are dropped before any other
+// filtering. It returns 0 when dedupe is off.
+func parseDedupeParams(r *http.Request, fe fieldErrors) int {
+	dedupe, err := parseBoolParam(r, "dedupe")
+	if fe.add("dedupe", err) || !dedupe {
+		return 0
+	}
+	v, err := parseIntParam(r, "dedupeMax", 3)
+	if err != nil || v < 1 || v > math.MaxInt32 {
+		fe.add("dedupeMax", errors.New("invalid dedupeMax (expected at least 1)"))
+		return 0
+	}
+	return int(v)
+}
+
+// parseMaxAgeParam parses maxAgeSec, excluding scans older than that many
+// seconds. It returns 0 when unset.
+func parseMaxAgeParam(r *http.Request) (int64, error) {
+	v, err := parseIntParam(r, "maxAgeSec", 0)
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid maxAgeSec")
+	}
+	return v, nil
+}
+
+// parseTrimPctParam parses the percent of prices trimmed from each end, any
+// integer from 0 to 50; the UI only offers multiples of 5.
+func parseTrimPctParam(r *http.Request) (int, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("trimPct"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.URL.Query().Get("trim"))
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.New("invalid trimPct")
+	}
+	if v < 0 || v > 50 {
+		return 0, errors.New("trimPct must be between 0 and 50")
+	}
+	return v, nil
+}
+
+func parseMAWindowParam(r *http.Request) (int, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("maWindow"))
+	if raw == "" {
+		return 1, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 {
+		return 0, errors.New("invalid maWindow")
+	}
+	if v > 500 {
+		return 500, nil
+	}
+	return v, nil
+}
+
+// applyMarketValue sets MarketValue on each point to the simple moving average
+// of the median over the trailing window scans (including the point itself).
+// points must already be sorted by TS.
+func applyMarketValue(points []seriesPoint, window int) {
+	if window < 1 {
+		window = 1
+	}
+	var sum float64
+	for i := range points {
+		sum += points[i].Median
+		if i >= window {
+			sum -= points[i-window].Median
+		}
+		if window == 1 {
+			points[i].MarketValue = points[i].Median
+			continue
+		}
+		n := i + 1
+		if n > window {
+			n = window
+		}
+		points[i].MarketValue = sum / float64(n)
+	}
+}
+
+// parseSeriesFormat returns "json" (default) or "csv", from the format param
+// or, absent that, an Accept: text/csv header.
+func parseSeriesFormat(r *http.Request) (string, error) {
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	if format == "" {
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+	switch format {
+	case "json", "csv", "ndjson":
+		return format, nil
+	default:
+		return "", errors.New("invalid format (expected json, csv or ndjson)")
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeSeriesCSV(w http.ResponseWriter, resp seriesResponse) {
+	filename := fmt.Sprintf("ahdb-%d-%s-%s.csv", resp.Item.ShortID,
+		time.Unix(resp.From, 0).UTC().Format("20060102"),
+		time.Unix(resp.To, 0).UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"scanId", "ts", "n", "min", "q1", "median", "q3", "max", "mean", "stddev"})
+	for _, p := range resp.Points {
+		_ = cw.Write([]string{
+			strconv.FormatInt(p.ScanID, 10),
+			strconv.FormatInt(p.TS, 10),
+			strconv.Itoa(p.N),
+			formatFloat(p.Min),
+			formatFloat(p.Q1),
+			formatFloat(p.Median),
+			formatFloat(p.Q3),
+			formatFloat(p.Max),
+			formatFloat(p.Mean),
+			formatFloat(p.Stddev),
+		})
+	}
+	cw.Flush()
+}
+
+// seriesNDJSONHeader is the first line of an NDJSON series response.
+type seriesNDJSONHeader struct {
+	Item       item   `json:"item"`
+	Realm      string `json:"realm"`
+	Faction    string `json:"faction"`
+	Unit       string `json:"unit"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+	TrimPct    int    `json:"trimPct"`
+	Aggregated bool   `json:"aggregated,omitempty"`
+	Points     int    `json:"points"` // number of point lines that follow
+}
+
+// ndjsonFlushEvery is how many points are written between flushes, so
+// clients can render wide ranges progressively.
+const ndjsonFlushEvery = 100
+
+func writeSeriesNDJSON(w http.ResponseWriter, resp seriesResponse) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(seriesNDJSONHeader{
+		Item:       resp.Item,
+		Realm:      resp.Realm,
+		Faction:    resp.Faction,
+		Unit:       resp.Unit,
+		From:       resp.From,
+		To:         resp.To,
+		TrimPct:    resp.TrimPct,
+		Aggregated: resp.Aggregated,
+		Points:     len(resp.Points),
+	})
+	_ = rc.Flush()
+	for i, pt := range resp.Points {
+		var line any = pt
+		if resp.fields != nil {
+			line = projectedPoint{pt, resp.fields}
+		}
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+		if (i+1)%ndjsonFlushEvery == 0 {
+			_ = rc.Flush()
+		}
+	}
+	_ = rc.Flush()
+}
+
+// factionBoth is the faction param value selecting Alliance and Horde, each
+// returned as its own set of points.
+const factionBoth = "both"
+
+// wildcard is the realm and faction param value selecting all of them. Scan
+// ids differ per realm and faction, so their auctions are instead pooled per
+// aggregateBucketSecs of scan time; trimPct, minN and the stats then apply
+// to each pooled bucket, and points have no scanId.
+const (
+	wildcard            = "*"
+	aggregateBucketSecs = 3600
+)
+
+// maxLastScans caps the lastScans param.
+const maxLastScans = 1000
+
+// maxMultiItems caps the number of items accepted by /api/series/multi.
+const maxMultiItems = 8
+
+// seriesParams are the validated query parameters shared by the series
+// endpoints.
+type seriesParams struct {
+	Unit        string
+	RoundMode   string
+	PriceExpr   string
+	PriceFilter string
+	Realm       string
+	Faction     string
+	From        int64
+	To          int64
+	MaxPoints   int
+	Sample      string
+	TrimPct     int
+	MAWindow    int
+	Weighted    bool
+	Humanize    bool
+	UnitScale   string
+	// MinN drops scans with fewer prices than this, counted after trimming.
+	MinN int
+	// Filters are the optional item attribute filters (minLevel, quality...).
+	Filters []filterCond
+	// PriceLimits drop out of range prices, before TrimPct applies.
+	PriceLimits priceLimits
+	// Fields restricts the JSON points to these keys, nil for all of them.
+	Fields map[string]bool
+	// Trend is the trend line fitted over the returned points, if any.
+	Trend string
+	// LastScans, when positive, limits the series to the most recent scans
+	// listing the items, in place of the from/to range.
+	LastScans int
+	// DedupeMax, when positive, keeps at most that many identical listings
+	// (same seller, price and itemCount) per scan, see parseDedupeParams.
+	DedupeMax int
+	// Partial makes loadSeries return the points read so far, along with
+	// errTruncated, when the query hits its deadline midway.
+	Partial bool
+}
+
+// errTruncated is returned by loadSeries with the partial points of a
+// seriesParams.Partial query that ran out of time.
+var errTruncated = errors.New("series truncated at the query deadline")
+
+// aggregated reports whether p pools several realms or factions together.
+func (p seriesParams) aggregated() bool {
+	return p.Realm == wildcard || p.Faction == wildcard
+}
+
+// roundFuncs maps the roundMode param to the SQL function used to round
+// per_item prices to whole copper.
+var roundFuncs = map[string]string{"round": "ROUND", "floor": "FLOOR", "ceil": "CEIL"}
+
+// unitPriceExpr returns the SQL price expression for unit, rounding per_item
+// prices with roundMode (round when empty). per_item_float keeps fractions
+// of a copper, so per item medians aren't quantized. The bid_ units price
+// auctions by their current bid instead of their buyout.
+func unitPriceExpr(unit, roundMode string) (string, error) {
+	col := unitPriceColumn(unit)
+	switch unit {
+	case "per_item", "bid_per_item":
+		fn, ok := roundFuncs[roundMode]
+		if !ok {
+			fn = "ROUND"
+		}
+		return fmt.Sprintf("CAST(%s(%s / a.itemCount) AS SIGNED)", fn, col), nil
+	case "per_item_float":
+		return col + " / a.itemCount", nil
+	case "per_stack", "bid_per_stack":
+		return col, nil
+	default:
+		return "", errors.New("invalid unit (expected per_item, per_item_float, per_stack, bid_per_item or bid_per_stack)")
+	}
+}
+
+// unitPriceColumn returns the auctions column unit prices come from.
+func unitPriceColumn(unit string) string {
+	if strings.HasPrefix(unit, "bid_") {
+		return "a.curBid"
+	}
+	return "a.buyout"
+}
+
+// priceFilter returns the SQL condition keeping the auctions that have a
+// price for unit: those with a buyout, or with a bid for the bid_ units.
+// With excludeZero, per item prices that round down to 0 (stacks priced
+// below half a copper per item) are dropped too.
+func priceFilter(unit, priceExpr string, excludeZero bool) string {
+	cond := unitPriceColumn(unit) + " > 0"
+	if excludeZero {
+		cond += " AND " + priceExpr + " > 0"
+	}
+	return cond
+}
+
+func parseRoundModeParam(r *http.Request) (string, error) {
+	mode := strings.TrimSpace(r.URL.Query().Get("roundMode"))
+	if mode == "" {
+		return "round", nil
+	}
+	if _, ok := roundFuncs[mode]; !ok {
+		return "", errors.New("invalid roundMode (expected round, floor or ceil)")
+	}
+	return mode, nil
+}
+
+func parseUnitParam(r *http.Request, roundMode string) (unit, priceExpr string, _ error) {
+	unit = strings.TrimSpace(r.URL.Query().Get("unit"))
+	if unit == "" {
+		unit = "per_item"
+	}
+	priceExpr, err := unitPriceExpr(unit, roundMode)
+	if err != nil {
+		return "", "", err
+	}
+	return unit, priceExpr, nil
+}
+
+// parseSeriesParams validates everything but the item selection.
+func (s *server) parseSeriesParams(ctx context.Context, r *http.Request) (seriesParams, error) {
+	var p seriesParams
+	var err error
+	if p.Realm, p.Faction, err = s.realmFactionParams(ctx, r); err != nil {
+		return p, err
+	}
+
+	// The remaining params are all validated so that every bad one is
+	// reported at once.
+	fe := fieldErrors{}
+	p.RoundMode, err = parseRoundModeParam(r)
+	fe.add("roundMode", err)
+	p.Unit, p.PriceExpr, err = parseUnitParam(r, p.RoundMode)
+	fe.add("unit", err)
+	excludeZero, err := parseBoolParam(r, "excludeZeroUnitPrice")
+	fe.add("excludeZeroUnitPrice", err)
+	p.PriceFilter = priceFilter(p.Unit, p.PriceExpr, excludeZero)
+	now := time.Now().Unix()
+	p.To, err = parseTimeParam(r, "to", now)
+	toBad := fe.add("to", err)
+	p.From, err = parseTimeParam(r, "from", -1)
+	fromBad := fe.add("from", err)
+	if !fromBad && p.From < 0 {
+		days, err := parseIntParam(r, "days", s.defaultDays)
+		fromBad = fe.add("days", err)
+		if days <= 0 {
+			p.From = 0
+		} else {
+			p.From = p.To - days*86400
+		}
+	}
+	if !toBad && !fromBad && p.From > p.To {
+		fe.add("from", errors.New("from must be <= to"))
+	}
+	lastScans, err := parseIntParam(r, "lastScans", 0)
+	if err != nil || lastScans < 0 || lastScans > maxLastScans {
+		fe.add("lastScans", fmt.Errorf("invalid lastScans (expected 1..%d)", maxLastScans))
+	} else if lastScans > 0 {
+		if p.aggregated() || p.Faction == factionBoth {
+			fe.add("lastScans", errors.New("lastScans needs a single realm and faction"))
+		}
+		// lastScans takes precedence over from, to and days.
+		p.LastScans = int(lastScans)
+		p.From, p.To = 0, now
+	}
+	maxAge, err := parseMaxAgeParam(r)
+	if !fe.add("maxAgeSec", err) && maxAge > 0 {
+		// An extra bound on top of from/to: a window entirely older than
+		// maxAgeSec is simply empty.
+		p.From = max(p.From, now-maxAge)
+	}
+
+	p.MaxPoints, err = parseMaxPointsParam(r)
+	fe.add("maxPoints", err)
+	p.Sample, err = parseSampleParam(r)
+	fe.add("sample", err)
+	p.TrimPct, err = parseTrimPctParam(r)
+	fe.add("trimPct", err)
+	p.MAWindow, err = parseMAWindowParam(r)
+	fe.add("maWindow", err)
+	p.Weighted, err = parseBoolParam(r, "weighted")
+	fe.add("weighted", err)
+	p.Humanize, err = parseBoolParam(r, "humanize")
+	fe.add("humanize", err)
+	p.UnitScale, err = parseUnitScaleParam(r)
+	fe.add("unitScale", err)
+	minN, err := parseIntParam(r, "minN", 0)
+	if err != nil || minN < 0 || minN > math.MaxInt32 {
+		fe.add("minN", errors.New("invalid minN"))
+	}
+	p.MinN = int(minN)
+	p.Filters = parseSeriesFilters(r, fe)
+	p.PriceLimits = parsePriceLimitsParams(r, fe)
+	p.Fields, err = parseFieldsParam(r)
+	fe.add("fields", err)
+	p.Trend, err = parseTrendParam(r)
+	fe.add("trend", err)
+	p.DedupeMax = parseDedupeParams(r, fe)
+	return p, fe.err()
+}
+
+// placeholders returns n comma separated SQL placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// loadItems looks up the given item ids, returning them keyed by id.
+func (s *server) loadItems(ctx context.Context, ids []string) (map[string]item, error) {
+	defer s.metrics.timeQuery("items_by_id")()
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := s.readDB.QueryContext(ctx,
+		`SELECT id, name, shortid FROM items WHERE id IN (`+placeholders(len(ids))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]item, len(ids))
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.ID, &it.Name, &it.ShortID); err != nil {
+			return nil, err
+		}
+		res[it.ID] = it
+	}
+	return res, rows.Err()
+}
+
+// seriesKey identifies one set of points returned by loadSeries.
+type seriesKey struct {
+	itemID  string
+	faction string
+}
+
+// seriesRow is one auction row of the series query.
+type seriesRow struct {
+	key    seriesKey
+	scanID int64
+	ts     int64
+	price  float64
+	count  int64
+	seller string // only selected with DedupeMax
+}
+
+// listingKey identifies identical listings for seriesParams.DedupeMax.
+type listingKey struct {
+	seller string
+	price  float64
+	count  int64
+}
+
+// querySeriesRows runs the series query for itemIDs, calling fn for each row.
+// Rows come ordered by item, scan and price, or, when p is aggregated, by
+// item, ts bucket and price (faction first with faction=both). With
+// p.Partial, it stops with errTruncated once ctx's deadline passes after
+// some rows were read.
+func (s *server) querySeriesRows(ctx context.Context, p seriesParams, itemIDs []string, fn func(seriesRow)) error {
+	args := make([]any, 0, len(itemIDs)+4)
+	for _, id := range itemIDs {
+		args = append(args, id)
+	}
+	var conds []string
+	if p.Realm != wildcard {
+		conds = append(conds, "AND s.realm = ?")
+		args = append(args, p.Realm)
+	}
+	switch p.Faction {
+	case wildcard:
+	case factionBoth:
+		conds = append(conds, "AND s.faction IN ('Alliance', 'Horde')")
+	default:
+		conds = append(conds, "AND s.faction = ?")
+		args = append(args, p.Faction)
+	}
+	join := ""
+	if len(p.Filters) > 0 {
+		join = "JOIN items i ON i.id = a.itemId"
+		for _, f := range p.Filters {
+			conds = append(conds, "AND "+f.cond)
+			args = append(args, f.arg)
+		}
+	}
+	if p.LastScans > 0 {
+		// A derived table as MySQL doesn't support LIMIT in IN subqueries.
+		// It applies the same price and item filters as the outer query, so
+		// scans without any matching auction don't use up the N slots.
+		var lastJoin string
+		lastConds := []string{"AND s.realm = ?", "AND s.faction = ?"}
+		lastArgs := make([]any, 0, len(itemIDs)+len(p.Filters)+3)
+		for _, id := range itemIDs {
+			lastArgs = append(lastArgs, id)
+		}
+		lastArgs = append(lastArgs, p.Realm, p.Faction)
+		if len(p.Filters) > 0 {
+			lastJoin = "JOIN items i ON i.id = a.itemId"
+			for _, f := range p.Filters {
+				lastConds = append(lastConds, "AND "+f.cond)
+				lastArgs = append(lastArgs, f.arg)
+			}
+		}
+		join += fmt.Sprintf(`
+JOIN (SELECT a.scanId
+      FROM auctions a
+      JOIN scanmeta s ON s.id = a.scanId
+      %s
+      WHERE a.itemId IN (%s)
+        AND %s
+        AND a.itemCount > 0
+        %s
+      GROUP BY a.scanId
+      ORDER BY MAX(s.ts) DESC
+      LIMIT ?) last ON last.scanId = a.scanId`, lastJoin, placeholders(len(itemIDs)), p.PriceFilter, strings.Join(lastConds, "\n        "))
+		args = append(append(lastArgs, p.LastScans), args...)
+	}
+	args = append(args, p.From, p.To)
+
+	scanExpr, tsExpr, order := "a.scanId", "UNIX_TIMESTAMP(s.ts)", "a.itemId, a.scanId, price"
+	if p.aggregated() {
+		scanExpr = "0"
+		tsExpr = fmt.Sprintf("UNIX_TIMESTAMP(s.ts) DIV %d * %d", aggregateBucketSecs, aggregateBucketSecs)
+		order = "a.itemId, ts, price"
+		if p.Faction == factionBoth {
+			// Each faction keeps its own points, so its rows must stay together.
+			order = "a.itemId, s.faction, ts, price"
+		}
+	}
+	sellerExpr := "''"
+	if p.DedupeMax > 0 {
+		sellerExpr = "COALESCE(a.seller, '')"
+	}
+	query := fmt.Sprintf(`
+SELECT a.itemId, s.faction, %s AS scanId, %s AS ts, %s AS price, a.itemCount, %s
+FROM auctions a
+JOIN scanmeta s ON s.id = a.scanId
+%s
+WHERE a.itemId IN (%s)
+  AND %s
+  AND a.itemCount > 0
+  %s
+  AND s.ts BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)
+ORDER BY %s`, scanExpr, tsExpr, p.PriceExpr, sellerExpr, join, placeholders(len(itemIDs)), p.PriceFilter, strings.Join(conds, "\n  "), order)
+
+	defer s.metrics.timeQuery("series")()
+	defer s.explainIfSlow(s.readDB, "series", time.Now(), query, args)
+	rows, err := s.readDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var n int64
+	var prev seriesRow
+	seen := make(map[listingKey]int)
+	for rows.Next() {
+		if p.Partial && n > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errTruncated
+		}
+		if n++; s.maxResultRows > 0 && n > s.maxResultRows {
+			return &statusError{http.StatusRequestEntityTooLarge,
+				fmt.Errorf("more than %d auction rows match, use a narrower time range", s.maxResultRows)}
+		}
+		var row seriesRow
+		if err := rows.Scan(&row.key.itemID, &row.key.faction, &row.scanID, &row.ts, &row.price, &row.count, &row.seller); err != nil {
+			return err
+		}
+		if p.DedupeMax > 0 {
+			if row.key != prev.key || row.scanID != prev.scanID || row.ts != prev.ts {
+				clear(seen)
+			}
+			prev = row
+			lk := listingKey{row.seller, row.price, row.count}
+			if seen[lk]++; seen[lk] > p.DedupeMax {
+				continue
+			}
+		}
+		if p.Faction != factionBoth {
+			// Keep the requested spelling (or wildcard) so callers can look
+			// it up.
+			row.key.faction = p.Faction
+		}
+		fn(row)
+	}
+	if err := rows.Err(); p.Partial && n > 0 && errors.Is(err, context.DeadlineExceeded) {
+		return errTruncated
+	}
+	return rows.Err()
+}
+
+// loadSeries computes the per-scan points for each of itemIDs with a single
+// query, returning them keyed by item and faction, sorted by TS and truncated
+// to p.MaxPoints. With p.Partial, a query running out of time returns the
+// complete scans read so far and errTruncated.
+func (s *server) loadSeries(ctx context.Context, p seriesParams, itemIDs []string) (map[seriesKey][]seriesPoint, error) {
+	res := make(map[seriesKey][]seriesPoint, len(itemIDs))
+	acc := scanAccumulator{prices: make([]float64, 0, 256)}
+	var curKey seriesKey
+	var curScanID int64 = -1
+	var curTS int64
+	flush := func() {
+		limited := acc.limited(p.PriceLimits)
+		if pt := limited.point(p.TrimPct, p.Weighted); pt.N >= p.MinN && pt.N > 0 {
+			res[curKey] = append(res[curKey], pt)
+		}
+	}
+	err := s.querySeriesRows(ctx, p, itemIDs, func(row seriesRow) {
+		if curScanID == -1 {
+			curKey = row.key
+			curScanID = row.scanID
+			curTS = row.ts
+			acc.reset(row.scanID, row.ts)
+		}
+		// Aggregated rows all have scanID 0, their ts bucket tells them apart.
+		if row.scanID != curScanID || row.ts != curTS || row.key != curKey {
+			flush()
+			curKey = row.key
+			curScanID = row.scanID
+			curTS = row.ts
+			acc.reset(row.scanID, row.ts)
+		}
+		acc.add(row.price, row.count)
+	})
+	truncated := errors.Is(err, errTruncated)
+	if err != nil && !truncated {
+		return nil, err
+	}
+	// The scan being read when time ran out is missing rows.
+	if len(acc.prices) > 0 && !truncated {
+		flush()
+	}
+
+	for key, points := range res {
+		sort.Slice(points, func(i, j int) bool { return points[i].TS < points[j].TS })
+		applyMarketValue(points, p.MAWindow)
+		points = samplePoints(points, p.MaxPoints, p.Sample)
+		markExtremes(points)
+		for i := range points {
+			if p.Humanize {
+				points[i].humanize()
+			}
+			if p.UnitScale == "gold" {
+				points[i].addGold()
+			}
+		}
+		res[key] = points
+	}
+	if truncated {
+		return res, errTruncated
+	}
+	return res, nil
+}
+
+// markExtremes flags the lowest and highest median points of the TS sorted
+// points.
+func markExtremes(points []seriesPoint) {
+	if len(points) == 0 {
+		return
+	}
+	lo, hi := 0, 0
+	for i := range points {
+		if points[i].Median < points[lo].Median {
+			lo = i
+		}
+		if points[i].Median > points[hi].Median {
+			hi = i
+		}
+	}
+	points[lo].IsLowest = true
+	points[hi].IsHighest = true
+}
+
+// response builds the seriesResponse for it out of the loadSeries result.
+func (p seriesParams) response(it item, series map[seriesKey][]seriesPoint) seriesResponse {
+	resp := seriesResponse{
+		Item:     it,
+		Realm:    p.Realm,
+		Faction:  p.Faction,
+		Unit:     p.Unit,
+		From:     p.From,
+		To:       p.To,
+		TrimPct:  p.TrimPct,
+		MAWindow: p.MAWindow,
+		Weighted: p.Weighted,
+		MinN:     p.MinN,
+		Sample:   p.Sample,
+
+		Aggregated: p.aggregated(),
+		fields:     p.Fields,
+	}
+	if p.Faction != factionBoth {
+		resp.Points = series[seriesKey{it.ID, p.Faction}]
+		resp.Summary = summarize(resp.Points)
+		if p.Trend == "linear" {
+			resp.Trend = linearTrend(resp.Points)
+		}
+		return resp
+	}
+	resp.Factions = make(map[string][]seriesPoint, 2)
+	for _, faction := range []string{"Alliance", "Horde"} {
+		resp.Factions[faction] = series[seriesKey{it.ID, faction}]
+	}
+	return resp
+}
+
+func (s *server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	itemID := strings.TrimSpace(r.URL.Query().Get("itemId"))
+	var shortID int
+	if raw := strings.TrimSpace(r.URL.Query().Get("shortId")); itemID == "" && raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid shortId")
+			return
+		}
+		shortID = v
+	} else if err := validateItemID(itemID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+	format, err := parseSeriesFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format != "json" && p.Faction == factionBoth {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("format=%s is not supported with faction=both", format))
+		return
+	}
+	if format == "csv" && p.Fields != nil {
+		writeError(w, http.StatusBadRequest, "fields is not supported with format=csv")
+		return
+	}
+	// csv and ndjson have no room for the truncated flag.
+	p.Partial = format == "json"
+
+	var it item
+	if shortID > 0 {
+		it, err = s.itemByShortID(ctx, shortID)
+		itemID = it.ID
+	} else {
+		err = s.readDB.QueryRowContext(ctx, `SELECT id, name, shortid FROM items WHERE id = ? LIMIT 1`, itemID).Scan(&it.ID, &it.Name, &it.ShortID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series, err := s.loadSeries(ctx, p, []string{itemID})
+	if errors.Is(err, errTruncated) {
+		resp := p.response(it, series)
+		resp.Truncated = true
+		writeJSON(w, http.StatusPartialContent, resp.jsonValue())
+		return
+	}
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := p.response(it, series)
+	if !p.aggregated() {
+		// Aggregated points carry their bucket start, not a scan time.
+		setLastModified(w, resp.newestTS())
+		if format != "json" && notModified(w, r) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	switch format {
+	case "csv":
+		writeSeriesCSV(w, resp)
+	case "ndjson":
+		writeSeriesNDJSON(w, resp)
+	default:
+		writeJSONWithETag(w, r, resp.jsonValue())
+	}
+}
+
+// handleSeriesMulti returns one series per item of the comma separated
+// itemIds param, in the requested order.
+func (s *server) handleSeriesMulti(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var itemIDs []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(r.URL.Query().Get("itemIds"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		itemIDs = append(itemIDs, id)
+	}
+	if len(itemIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "missing itemIds")
+		return
+	}
+	if len(itemIDs) > maxMultiItems {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many itemIds (max %d)", maxMultiItems))
+		return
+	}
+	for _, id := range itemIDs {
+		if err := validateItemID(id); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error()+": "+id)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	p, err := s.parseSeriesParams(ctx, r)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	items, err := s.loadItems(ctx, itemIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, id := range itemIDs {
+		if _, ok := items[id]; !ok {
+			writeErrorCode(w, http.StatusNotFound, codeItemNotFound, "item not found: "+id)
+			return
+		}
+	}
+
+	series, err := s.loadSeries(ctx, p, itemIDs)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]seriesResponse, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		res = append(res, p.response(items[id], series))
+	}
+	writeJSONWithETag(w, r, seriesListJSON(res))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimitedPointAppliesLimitsBeforeTrim(t *testing.T) {
+	newAcc := func(prices ...float64) *scanAccumulator {
+		a := &scanAccumulator{}
+		for _, p := range prices {
+			a.add(p, 1)
+		}
+		return a
+	}
+	tests := []struct {
+		name          string
+		prices        []float64
+		limits        priceLimits
+		trimPct       int
+		wantN         int
+		wantMin       float64
+		wantMax       float64
+		wantQuantity  int64
+		wantEmptyScan bool
+	}{
+		{
+			name:    "no limits, trim only",
+			prices:  []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 1000},
+			limits:  noPriceLimits,
+			trimPct: 10,
+			wantN:   9, wantMin: 2, wantMax: 10, wantQuantity: 11,
+		},
+		{
+			// The outlier is dropped by maxPrice first, so the 10% trim then
+			// applies to the 10 remaining prices.
+			name:    "maxPrice then trim",
+			prices:  []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 1000},
+			limits:  priceLimits{Min: 0, Max: 100},
+			trimPct: 10,
+			wantN:   8, wantMin: 2, wantMax: 9, wantQuantity: 11,
+		},
+		{
+			name:    "minPrice and maxPrice then trim",
+			prices:  []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 1000},
+			limits:  priceLimits{Min: 3, Max: 100},
+			trimPct: 20,
+			wantN:   6, wantMin: 4, wantMax: 9, wantQuantity: 11,
+		},
+		{
+			name:          "limits dropping everything",
+			prices:        []float64{1, 2, 3},
+			limits:        priceLimits{Min: 50, Max: 100},
+			trimPct:       10,
+			wantEmptyScan: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAcc(tt.prices...)
+			limited := a.limited(tt.limits)
+			pt := limited.point(tt.trimPct, false)
+			if tt.wantEmptyScan {
+				if pt.N != 0 {
+					t.Fatalf("N = %d, want 0", pt.N)
+				}
+				return
+			}
+			if pt.N != tt.wantN || pt.Min != tt.wantMin || pt.Max != tt.wantMax {
+				t.Errorf("N, Min, Max = %d, %v, %v, want %d, %v, %v", pt.N, pt.Min, pt.Max, tt.wantN, tt.wantMin, tt.wantMax)
+			}
+			if pt.Quantity != tt.wantQuantity {
+				t.Errorf("Quantity = %d, want %d (limits must not drop quantity)", pt.Quantity, tt.wantQuantity)
+			}
+		})
+	}
+}
+
+func TestLoadSeriesMinN(t *testing.T) {
+	// Two scans: 3 and 2 auctions.
+	rows := [][]driver.Value{
+		{"i1", "Horde", int64(1), int64(1000), float64(10), int64(1), ""},
+		{"i1", "Horde", int64(1), int64(1000), float64(20), int64(1), ""},
+		{"i1", "Horde", int64(1), int64(1000), float64(30), int64(1), ""},
+		{"i1", "Horde", int64(2), int64(2000), float64(15), int64(1), ""},
+		{"i1", "Horde", int64(2), int64(2000), float64(25), int64(1), ""},
+	}
+	tests := []struct {
+		minN      int
+		wantScans []int64
+	}{
+		{minN: 2, wantScans: []int64{1, 2}},
+		{minN: 3, wantScans: []int64{1}}, // exactly minN is kept, minN-1 dropped
+		{minN: 4, wantScans: nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint("minN=", tt.minN), func(t *testing.T) {
+			db := newFakeDB(t, fakeRowsFor("FROM auctions a", fakeResult{
+				columns: []string{"itemId", "faction", "scanId", "ts", "price", "itemCount", "seller"},
+				rows:    rows,
+			}))
+			s := &server{db: db, readDB: db}
+			p := seriesParams{
+				Unit: "per_item", PriceExpr: "a.buyout", PriceFilter: "a.buyout > 0",
+				Realm: "Stormrage", Faction: "Horde", To: 3000,
+				MaxPoints: 1000, PriceLimits: noPriceLimits, MinN: tt.minN,
+			}
+			series, err := s.loadSeries(context.Background(), p, []string{"i1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, pt := range series[seriesKey{"i1", "Horde"}] {
+				got = append(got, pt.ScanID)
+			}
+			if !reflect.DeepEqual(got, tt.wantScans) {
+				t.Errorf("scans = %v, want %v", got, tt.wantScans)
+			}
+		})
+	}
+}
+
+func TestTrimPct3(t *testing.T) {
+	for _, raw := range []string{"trimPct=3", "trim=3"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/series?"+raw, nil)
+		if got, err := parseTrimPctParam(r); err != nil || got != 3 {
+			t.Errorf("parseTrimPctParam(%s) = %d, %v, want 3", raw, got, err)
+		}
+	}
+
+	// trimCount rounds n*3% down, so small scans aren't trimmed at all.
+	tests := []struct {
+		n, wantTrim int
+	}{
+		{1, 0},
+		{2, 0},
+		{10, 0},
+		{33, 0},
+		{34, 1},
+		{66, 1},
+		{67, 2},
+		{100, 3},
+	}
+	for _, tt := range tests {
+		if got := trimCount(tt.n, 3); got != tt.wantTrim {
+			t.Errorf("trimCount(%d, 3) = %d, want %d", tt.n, got, tt.wantTrim)
+		}
+		values := make([]float64, tt.n)
+		for i := range values {
+			values[i] = float64(i)
+		}
+		if got := len(trimSorted(values, 3)); got != tt.n-2*tt.wantTrim {
+			t.Errorf("len(trimSorted(%d values, 3)) = %d, want %d", tt.n, got, tt.n-2*tt.wantTrim)
+		}
+	}
+}
+
+func TestQuerySeriesRowsLastScansFilters(t *testing.T) {
+	var gotQuery string
+	var gotArgs []any
+	db := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		gotQuery = query
+		for _, a := range args {
+			gotArgs = append(gotArgs, a.Value)
+		}
+		return fakeResult{columns: []string{"itemId", "faction", "scanId", "ts", "price", "itemCount", "seller"}}
+	})
+	s := &server{db: db, readDB: db}
+	p := seriesParams{
+		PriceExpr: "a.buyout", PriceFilter: "a.buyout > 0",
+		Realm: "Stormrage", Faction: "Horde", To: 3000, LastScans: 5,
+		Filters: []filterCond{{cond: "i.Rarity = ?", arg: 3}},
+	}
+	if err := s.querySeriesRows(context.Background(), p, []string{"i1"}, func(seriesRow) {}); err != nil {
+		t.Fatal(err)
+	}
+	start := strings.Index(gotQuery, "JOIN (SELECT")
+	end := strings.Index(gotQuery, ") last ON")
+	if start < 0 || end < start {
+		t.Fatalf("no lastScans derived table in:\n%s", gotQuery)
+	}
+	derived := gotQuery[start:end]
+	for _, want := range []string{"a.buyout > 0", "JOIN items i ON i.id = a.itemId", "i.Rarity = ?", "a.itemCount > 0"} {
+		if !strings.Contains(derived, want) {
+			t.Errorf("derived table lacks %q:\n%s", want, derived)
+		}
+	}
+	// Derived table args (items, realm, faction, filters, N), then the outer ones.
+	want := []any{"i1", "Stormrage", "Horde", int64(3), int64(5), "i1", "Stormrage", "Horde", int64(3), int64(0), int64(3000)}
+	if !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %v, want %v", gotArgs, want)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hashedAsset matches asset names with a content hash, like app.3f9c2b1e.js,
+// which can be cached forever as a new version gets a new name.
+var hashedAsset = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// cacheControl returns the Cache-Control header for the web asset name.
+// index.html is revalidated each time so new deployments show up at once.
+func cacheControl(name string) string {
+	switch {
+	case name == "" || name == "index.html":
+		return "no-cache"
+	case hashedAsset.MatchString(name):
+		return "public, max-age=31536000, immutable"
+	default:
+		return "public, max-age=3600"
+	}
+}
+
+// compressible reports whether the asset name is text worth gzipping on the
+// fly; images and fonts are already compressed.
+func compressible(name string) bool {
+	switch path.Ext(name) {
+	case "", ".html", ".js", ".mjs", ".css", ".json", ".map", ".svg", ".txt":
+		return true
+	}
+	return false
+}
+
+// staticHandler serves the web assets with Cache-Control headers, gzipped:
+// from a pre-compressed name.gz next to the asset when there is one, else on
+// the fly for text assets. With spa set, paths without a file extension that match no file
+// get index.html, so client side routes can be reloaded and shared; missing
+// assets (.js, .css...) still 404.
+func staticHandler(fsys fs.FS, spa bool) http.Handler {
+	files := http.FileServer(http.FS(fsys))
+	gzipFiles := gzipHandler(files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if spa && name != "" && path.Ext(name) == "" {
+			if _, err := fs.Stat(fsys, name); err != nil {
+				r = r.Clone(r.Context())
+				r.URL.Path = "/"
+				name = ""
+			}
+		}
+		w.Header().Set("Cache-Control", cacheControl(name))
+		if name != "" && acceptsGzip(r) && servePrecompressed(w, r, fsys, name) {
+			return
+		}
+		if compressible(name) {
+			if acceptsGzip(r) && r.Header.Get("Range") != "" {
+				// Byte ranges of the uncompressed file make no sense gzipped.
+				r = r.Clone(r.Context())
+				r.Header.Del("Range")
+			}
+			gzipFiles.ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// servePrecompressed serves name.gz for name if it exists, reporting whether
+// it did.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	f, err := fsys.Open(name + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("Content-Encoding", "gzip")
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	var mod time.Time
+	if fi, err := f.Stat(); err == nil {
+		mod = fi.ModTime()
+	}
+	http.ServeContent(w, r, name, mod, rs)
+	return true
+}
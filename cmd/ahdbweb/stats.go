@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// statsCacheTTL is how long /api/stats responses are reused: the counts are
+// expensive on big tables and only move when scans are imported.
+const statsCacheTTL = time.Minute
+
+type statsResponse struct {
+	Items         int64  `json:"items"`
+	Scans         int64  `json:"scans"`
+	Auctions      int64  `json:"auctions"`
+	Realms        int64  `json:"realms"`
+	RealmFactions int64  `json:"realmFactions"`
+	OldestScanTS  int64  `json:"oldestScanTs"`
+	NewestScanTS  int64  `json:"newestScanTs"`
+	OldestScanISO string `json:"oldestScanIso,omitempty"`
+	NewestScanISO string `json:"newestScanIso,omitempty"`
+}
+
+// handleStats reports DB wide counts and the scan time range.
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	done := s.metrics.timeQuery("stats")
+	var res statsResponse
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM items`).Scan(&res.Items); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM auctions`).Scan(&res.Auctions); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var oldest, newest sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*), COUNT(DISTINCT realm), COUNT(DISTINCT realm, faction),
+       UNIX_TIMESTAMP(MIN(ts)), UNIX_TIMESTAMP(MAX(ts))
+FROM scanmeta`).Scan(&res.Scans, &res.Realms, &res.RealmFactions, &oldest, &newest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	if oldest.Valid && newest.Valid {
+		res.OldestScanTS, res.NewestScanTS = oldest.Int64, newest.Int64
+		res.OldestScanISO, res.NewestScanISO = formatTS(oldest.Int64), formatTS(newest.Int64)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
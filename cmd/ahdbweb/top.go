@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type topItem struct {
+	Item          item  `json:"item"`
+	AuctionCount  int64 `json:"auctionCount"`
+	TotalQuantity int64 `json:"totalQuantity"`
+}
+
+func parseTopLimitParam(r *http.Request) (int64, error) {
+	limit, err := parseIntParam(r, "limit", 20)
+	if err != nil || limit < 1 || limit > 100 {
+		return 0, errors.New("invalid limit (expected 1..100)")
+	}
+	return limit, nil
+}
+
+// handleTopItems lists the items with the most auctions in a scan (scanId),
+// over a range of scans (from/to), or by default in the latest scan of the
+// realm/faction.
+func (s *server) handleTopItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, err := parseTopLimitParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "top items")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var cond string
+	var args []any
+	switch {
+	case strings.TrimSpace(q.Get("scanId")) != "":
+		scanID, err := parseScanIDParam(r, "scanId")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cond, args = "s.id = ?", []any{scanID}
+	case strings.TrimSpace(q.Get("from")) != "" || strings.TrimSpace(q.Get("to")) != "":
+		fe := fieldErrors{}
+		to, err := parseTimeParam(r, "to", time.Now().Unix())
+		fe.add("to", err)
+		from, err := parseTimeParam(r, "from", 0)
+		fe.add("from", err)
+		if len(fe) == 0 && from > to {
+			fe.add("from", errors.New("from must be <= to"))
+		}
+		if err := fe.err(); err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+		cond, args = "s.ts BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)", []any{from, to}
+	default:
+		var scanID int64
+		err := s.db.QueryRowContext(ctx,
+			`SELECT id FROM scanmeta WHERE realm = ? AND faction = ? ORDER BY ts DESC LIMIT 1`,
+			realm, faction,
+		).Scan(&scanID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusOK, []topItem{})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		cond, args = "s.id = ?", []any{scanID}
+	}
+
+	done := s.metrics.timeQuery("top_items")
+	rows, err := s.db.QueryContext(ctx, `
+SELECT i.id, i.name, i.shortid, COUNT(*) AS auctionCount, SUM(a.itemCount) AS totalQuantity
+FROM auctions a
+JOIN scanmeta s ON s.id = a.scanId
+JOIN items i ON i.id = a.itemId
+WHERE s.realm = ?
+  AND s.faction = ?
+  AND `+cond+`
+GROUP BY i.id, i.name, i.shortid
+ORDER BY auctionCount DESC, i.id
+LIMIT ?`, append(append([]any{realm, faction}, args...), limit)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	res := make([]topItem, 0, limit)
+	for rows.Next() {
+		var ti topItem
+		if err := rows.Scan(&ti.Item.ID, &ti.Item.Name, &ti.Item.ShortID, &ti.AuctionCount, &ti.TotalQuantity); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res = append(res, ti)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	writeJSON(w, http.StatusOK, res)
+}
+
+type recentItems struct {
+	Realm   string    `json:"realm"`
+	Faction string    `json:"faction"`
+	ScanID  int64     `json:"scanId"`
+	TS      int64     `json:"ts"`
+	Order   string    `json:"order"`
+	Items   []topItem `json:"items"`
+}
+
+// handleRecentItems lists the items of the latest scan of the realm/faction,
+// by total quantity listed (order=quantity, the default) or auction count
+// (order=count), to give an instant populated list to pick from.
+func (s *server) handleRecentItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, err := parseTopLimitParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order := strings.TrimSpace(r.URL.Query().Get("order"))
+	var orderBy string
+	switch order {
+	case "", "quantity":
+		order, orderBy = "quantity", "totalQuantity DESC, auctionCount DESC"
+	case "count":
+		orderBy = "auctionCount DESC, totalQuantity DESC"
+	default:
+		writeError(w, http.StatusBadRequest, "invalid order (expected quantity|count)")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.seriesTimeout)
+	defer cancel()
+
+	realm, faction, err := s.singleRealmFactionParams(ctx, r, "recent items")
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	res := recentItems{Realm: realm, Faction: faction, Order: order, Items: []topItem{}}
+	err = s.db.QueryRowContext(ctx,
+		`SELECT id, UNIX_TIMESTAMP(ts) FROM scanmeta WHERE realm = ? AND faction = ? ORDER BY ts DESC LIMIT 1`,
+		realm, faction,
+	).Scan(&res.ScanID, &res.TS)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	done := s.metrics.timeQuery("recent_items")
+	rows, err := s.db.QueryContext(ctx, `
+SELECT i.id, i.name, i.shortid, COUNT(*) AS auctionCount, SUM(a.itemCount) AS totalQuantity
+FROM auctions a
+JOIN items i ON i.id = a.itemId
+WHERE a.scanId = ?
+GROUP BY i.id, i.name, i.shortid
+ORDER BY `+orderBy+`, i.id
+LIMIT ?`, res.ScanID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ti topItem
+		if err := rows.Scan(&ti.Item.ID, &ti.Item.Name, &ti.Item.ShortID, &ti.AuctionCount, &ti.TotalQuantity); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res.Items = append(res.Items, ti)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done()
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)" ./cmd/ahdbweb
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// buildVersion returns the build information. Without -ldflags the commit
+// falls back to the VCS revision the go tool stamps into binaries.
+func buildVersion() versionResponse {
+	res := versionResponse{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok && res.Commit == "dev" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				res.Commit = s.Value
+			}
+		}
+	}
+	return res
+}
+
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, buildVersion())
+}
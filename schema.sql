@@ -47,6 +47,7 @@ create table if not exists auctions (
 
 CREATE index buyoutidx ON auctions (buyout);
 CREATE fulltext index nameidx on items (name);
+CREATE index nameprefixidx on items (name); # for match=prefix item searches
 CREATE index rarityidx on items (rarity);
 CREATE index sellpriceidx on items (sellprice);
 CREATE index itemididx on auctions (itemid);